@@ -0,0 +1,156 @@
+package modbusd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Addressing int
+
+/*
+ * Addressing is the convention by which an absolute address identifies both
+ * the table (coils, discrete inputs, input or holding registers) and the
+ * relative address of an item. Internally absolute addresses are 6 digits
+ * from 0, the convention of Relative and Absolute.
+ */
+const (
+	ZeroBased6 Addressing = 0 // 6 digits from 0, e.g. 400000 is holding register 0
+	OneBased6  Addressing = 1 // 6 digits from 1, e.g. 400001 is holding register 0
+	OneBased5  Addressing = 2 // 5 digits from 1, e.g. 40001 is holding register 0
+	Prefixed   Addressing = 3 // Table prefix and relative address, e.g. hr:0
+)
+
+var addressings = map[Addressing]string{
+	ZeroBased6: "6zero",
+	OneBased6:  "6one",
+	OneBased5:  "5one",
+	Prefixed:   "prefix",
+}
+
+// Table prefixes of the Prefixed addressing, by the first digit of the 6 digit address
+var prefixes = map[uint64]string{
+	0: "co",
+	1: "di",
+	3: "ir",
+	4: "hr",
+}
+
+// ParseAddressing parses the name of an addressing convention, e.g. 5one
+func ParseAddressing(name string) (Addressing, error) {
+	for addressing, n := range addressings {
+		if strings.EqualFold(n, name) {
+			return addressing, nil
+		}
+	}
+	return ZeroBased6, fmt.Errorf("Unknown addressing: %s", name)
+}
+
+func (a Addressing) String() string {
+	if name, found := addressings[a]; found {
+		return name
+	}
+	return fmt.Sprintf("Addressing(%d)", int(a))
+}
+
+// canonical converts an address in the convention to 6 digits from 0
+func (a Addressing) canonical(address string) (uint64, error) {
+	var table, offset uint64
+	switch a {
+	case ZeroBased6, OneBased6, OneBased5:
+		u64, err := strconv.ParseUint(address, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to parse address: %s", address)
+		}
+		scale := uint64(100000)
+		if a == OneBased5 {
+			scale = 10000
+		}
+		table, offset = u64/scale, u64%scale
+		if a != ZeroBased6 {
+			if offset == 0 {
+				return 0, fmt.Errorf("Illegal address from 1: %s", address)
+			}
+			offset--
+		}
+	case Prefixed:
+		fields := strings.Split(address, ":")
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("Unable to parse address: %s", address)
+		}
+		found := false
+		for t, prefix := range prefixes {
+			if strings.EqualFold(prefix, fields[0]) {
+				table, found = t, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown table prefix: %s", fields[0])
+		}
+		u64, err := strconv.ParseUint(fields[1], 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to parse address: %s", address)
+		}
+		offset = u64
+	default:
+		return 0, fmt.Errorf("Unknown addressing: %v", a)
+	}
+	if _, found := prefixes[table]; !found || offset > 65535 {
+		return 0, fmt.Errorf("Illegal address: %s", address)
+	}
+	return table*100000 + offset, nil
+}
+
+// format converts an address of 6 digits from 0 to the convention
+func (a Addressing) format(canonical uint64) (string, error) {
+	table, offset := canonical/100000, canonical%100000
+	if _, found := prefixes[table]; !found || offset > 65535 {
+		return "", fmt.Errorf("Illegal address: %v", canonical)
+	}
+	switch a {
+	case ZeroBased6:
+		return fmt.Sprintf("%06d", canonical), nil
+	case OneBased6:
+		return fmt.Sprintf("%06d", table*100000+offset+1), nil
+	case OneBased5:
+		if offset+1 > 9999 {
+			return "", fmt.Errorf("Unable to express address in 5 digits: %v", canonical)
+		}
+		return fmt.Sprintf("%05d", table*10000+offset+1), nil
+	case Prefixed:
+		return fmt.Sprintf("%s:%d", prefixes[table], offset), nil
+	default:
+		return "", fmt.Errorf("Unknown addressing: %v", a)
+	}
+}
+
+// Relative sets the read function code for an absolute address in the convention and returns the relative address
+func (a Addressing) Relative(address string, fncode *FnCode) (uint16, error) {
+	canonical, err := a.canonical(address)
+	if err != nil {
+		*fncode = FERR
+		return 0, err
+	}
+	relative, err := Relative(canonical, fncode)
+	return uint16(relative), err
+}
+
+// RelativeWrite sets the write function code for a quantity at an absolute address in the convention and returns the relative address
+func (a Addressing) RelativeWrite(address string, quantity uint16, fncode *FnCode) (uint16, error) {
+	canonical, err := a.canonical(address)
+	if err != nil {
+		*fncode = FERR
+		return 0, err
+	}
+	relative, err := RelativeWrite(canonical, quantity, fncode)
+	return uint16(relative), err
+}
+
+// Absolute returns the address in the convention of a relative address accessed by the function code
+func (a Addressing) Absolute(fncode FnCode, relative uint16) (string, error) {
+	canonical, err := Absolute(fncode, uint64(relative))
+	if err != nil {
+		return "", err
+	}
+	return a.format(canonical)
+}
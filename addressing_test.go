@@ -0,0 +1,142 @@
+package modbusd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddressingRoundTrip(t *testing.T) {
+	tests := []struct {
+		addressing Addressing
+		address    string
+		canonical  uint64
+	}{
+		{ZeroBased6, "400000", 400000},
+		{ZeroBased6, "000000", 0},
+		{ZeroBased6, "465535", 465535},
+		{OneBased6, "400001", 400000},
+		{OneBased6, "165536", 165535},
+		{OneBased6, "000001", 0},
+		{OneBased5, "40001", 400000},
+		{OneBased5, "39999", 309998},
+		{OneBased5, "00001", 0},
+		{Prefixed, "hr:0", 400000},
+		{Prefixed, "co:65535", 65535},
+		{Prefixed, "di:12", 100012},
+		{Prefixed, "ir:7", 300007},
+	}
+	for _, test := range tests {
+		canonical, err := test.addressing.canonical(test.address)
+		if err != nil || canonical != test.canonical {
+			t.Errorf("%v canonical(%q) = %v, %v; want %v", test.addressing, test.address, canonical, err, test.canonical)
+			continue
+		}
+		address, err := test.addressing.format(canonical)
+		if err != nil || address != test.address {
+			t.Errorf("%v format(%v) = %q, %v; want %q", test.addressing, canonical, address, err, test.address)
+		}
+	}
+	// Prefixes are case insensitive but formatted in lower case
+	if canonical, err := Prefixed.canonical("HR:3"); err != nil || canonical != 400003 {
+		t.Errorf("Prefixed canonical(HR:3) = %v, %v", canonical, err)
+	}
+}
+
+func TestAddressingIllegal(t *testing.T) {
+	tests := []struct {
+		addressing Addressing
+		address    string
+	}{
+		{OneBased6, "400000"},
+		{OneBased6, "465537"},
+		{OneBased5, "40000"},
+		{ZeroBased6, "465536"},
+		{ZeroBased6, "200000"},
+		{ZeroBased6, "hr:0"},
+		{Prefixed, "400000"},
+		{Prefixed, "xx:1"},
+		{Prefixed, "hr:65536"},
+		{Addressing(9), "400000"},
+	}
+	for _, test := range tests {
+		if canonical, err := test.addressing.canonical(test.address); err == nil {
+			t.Errorf("%v canonical(%q) = %v; want an error", test.addressing, test.address, canonical)
+		}
+	}
+	// Five digits only reach 9999 items of a table
+	if address, err := OneBased5.format(409999); err == nil {
+		t.Errorf("OneBased5 format(409999) = %q; want an error", address)
+	}
+	if address, err := OneBased5.format(409998); err != nil || address != "49999" {
+		t.Errorf("OneBased5 format(409998) = %q, %v", address, err)
+	}
+}
+
+func TestAddressingRelative(t *testing.T) {
+	var fncode FnCode
+	if relative, err := OneBased5.Relative("30010", &fncode); err != nil || relative != 9 || fncode != RDIR {
+		t.Errorf("Relative(30010) = %v, %v, %v", relative, fncode, err)
+	}
+	if relative, err := Prefixed.RelativeWrite("hr:4", 2, &fncode); err != nil || relative != 4 || fncode != WRMR {
+		t.Errorf("RelativeWrite(hr:4) = %v, %v, %v", relative, fncode, err)
+	}
+	if _, err := Prefixed.RelativeWrite("ir:4", 1, &fncode); err == nil {
+		t.Error("RelativeWrite to an input register")
+	}
+	if address, err := OneBased6.Absolute(RDCO, 0); err != nil || address != "000001" {
+		t.Errorf("Absolute(RDCO, 0) = %q, %v", address, err)
+	}
+	for _, addressing := range []Addressing{ZeroBased6, OneBased6, OneBased5, Prefixed} {
+		if parsed, err := ParseAddressing(addressing.String()); err != nil || parsed != addressing {
+			t.Errorf("ParseAddressing(%v) = %v, %v", addressing, parsed, err)
+		}
+	}
+}
+
+func TestClientAddressing(t *testing.T) {
+	memory := NewMemory(10, 10, 10, 10)
+	memory.InputRegisters[4] = 77
+	server, err := NewServer(memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	address := listener.Addr().(*net.TCPAddr)
+	transport, _ := NewTCP(address.IP.String(), uint16(address.Port), time.Second)
+	protocol, _ := NewModbusTCP(1)
+	c := &Client{Protocol: protocol, Transport: transport, Addressing: OneBased5}
+	defer c.Close()
+
+	if err = c.WriteAbsolute("40002", []uint16{5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WriteAbsolute("00003", []uint16{1}); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := c.ReadAbsolute("40001", 3); err != nil || values[0] != 0 || values[1] != 5 || values[2] != 6 {
+		t.Errorf("ReadAbsolute(40001) = %v, %v", values, err)
+	}
+	if values, err := c.ReadAbsolute("30005", 1); err != nil || values[0] != 77 {
+		t.Errorf("ReadAbsolute(30005) = %v, %v", values, err)
+	}
+	// The same items in another convention
+	c.Addressing = Prefixed
+	if values, err := c.ReadAbsolute("co:1", 3); err != nil || values[0] != 0 || values[1] != 1 || values[2] != 0 {
+		t.Errorf("ReadAbsolute(co:1) = %v, %v", values, err)
+	}
+	if values, err := c.ReadAbsolute("hr:1", 1); err != nil || values[0] != 5 {
+		t.Errorf("ReadAbsolute(hr:1) = %v, %v", values, err)
+	}
+	if err = c.WriteAbsolute("di:0", []uint16{1}); err == nil {
+		t.Error("WriteAbsolute to a discrete input")
+	}
+	if _, err = c.ReadAbsolute("40001", 1); err == nil {
+		t.Error("ReadAbsolute of an address in another convention")
+	}
+}
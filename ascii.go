@@ -1,8 +1,11 @@
 package modbusd
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
+	"time"
 )
 
 type ASCII struct {
@@ -19,8 +22,13 @@ const COLON byte = 0x3A
 const CR byte = 0x0D
 const LF byte = 0x0A
 
-//TODO: Implement the ASCII protocol
-// Creates an instance of the RTU protocol class
+// Default inter-character timeout of the ASCII transmission mode
+const asciiTimeout time.Duration = 1 * time.Second
+
+// Maximum size of an ASCII frame including start and end of frame
+const MaxASCII int = 513
+
+// Creates an instance of the ASCII protocol class
 func NewASCII(slaveid byte) (*ASCII, error) {
 	ascii := &ASCII{}
 	ascii.SlaveId = slaveid
@@ -48,27 +56,29 @@ func (s *ASCII) toHex(text []byte) ([]byte, error) {
 	return bhex, nil
 }
 
-//Encode builds the ASCII modbus protocol header and add error checking
+// Encode builds the ASCII modbus protocol header and add error checking
 func (s *ASCII) Encode(pdu *PDU) (*ADU, error) {
-	// Construct the Application Data Unit for the Modbus TCP protocol
+	// Construct the Application Data Unit for the Modbus ASCII protocol
 	adu, err := NewADU(pdu)
-
-	// Start of frame control characters
-	adu.SOF = make([]byte, 1)
-	adu.SOF[0] = COLON
-	if adu.SOF, err = s.toASCII(adu.SOF); err != nil {
-		return nil, fmt.Errorf("Unable to encode header")
+	if err != nil {
+		return nil, err
 	}
 
-	// RTU header solely consists of the Slave Id
+	// ASCII header solely consists of the Slave Id
 	adu.Hdr = make([]byte, 1)
 	adu.SlaveId = s.SlaveId
 	adu.Hdr[0] = adu.SlaveId
 
+	// Implement error checking for ASCII over the binary slave id and PDU
+	if err = adu.ErrorLRC(); err != nil {
+		return nil, fmt.Errorf("Unable to calculate error checking: %s", err)
+	}
+
 	/*
 	 * Convert all of the ADU sections to their ASCII
 	 * representation that the ASCII modbus protocol
-	 * requires.
+	 * requires. The start and end of frame characters
+	 * are transmitted as is.
 	 */
 	if adu.Hdr, err = s.toASCII(adu.Hdr); err != nil {
 		return nil, fmt.Errorf("Unable to encode header")
@@ -82,69 +92,94 @@ func (s *ASCII) Encode(pdu *PDU) (*ADU, error) {
 		return nil, fmt.Errorf("Unable to encode data")
 	}
 
-	// Implement error checking for ASCII
-	adu.ErrorLRC()
 	if adu.Err, err = s.toASCII(adu.Err); err != nil {
 		return nil, fmt.Errorf("Unable to encode error checking")
 	}
 
-	// End of frame control characters
-	adu.EOF = make([]byte, 2)
-	adu.EOF[0] = CR
-	adu.EOF[1] = LF
-	if adu.EOF, err = s.toASCII(adu.EOF); err != nil {
-		return nil, fmt.Errorf("Unable to encode header")
-	}
+	// Start and end of frame control characters
+	adu.SOF = []byte{COLON}
+	adu.EOF = []byte{CR, LF}
 
-	return adu, err
+	return adu, nil
 }
 
 // decode calls on the ProtocolBase function
 func (s *ASCII) Decode(response []byte) (*ADU, error) {
-	var err error
-	var tmp *ADU
+	flen := int(LSOF + LEOF)
+	if len(response) < flen {
+		return nil, fmt.Errorf("%w: Response too short at %v bytes", ErrFrame, len(response))
+	}
 	/*
-	 * Convert all of the ADU sections to their hexadecimal (binary)
-	 * representation.
+	 * Convert the hex pairs between the start and end of frame
+	 * characters to their binary representation, leaving the frame
+	 * characters for the recover function to verify.
 	 */
-	if tmp.SOF, err = s.toHex(response[:2]); err != nil {
-		return nil, fmt.Errorf("Unable to encode header")
-	}
-
-	if tmp.Hdr, err = s.toHex(response[2:4]); err != nil {
-		return nil, fmt.Errorf("Unable to encode header")
-	}
-
-	if tmp.FnCode, err = s.toHex(response[4:6]); err != nil {
-		return nil, fmt.Errorf("Unable to encode function code")
-	}
-
-	if tmp.Data, err = s.toHex(response[6 : len(response)-6]); err != nil {
-		return nil, fmt.Errorf("Unable to encode data")
-	}
-
-	if tmp.Err, err = s.toHex(response[len(response)-6 : len(response)-4]); err != nil {
-		return nil, fmt.Errorf("Unable to encode error checking")
-	}
-
-	if tmp.EOF, err = s.toHex(response[len(response)-4 : len(response)]); err != nil {
-		return nil, fmt.Errorf("Unable to encode header")
-	}
-
-	var tmpBytes []byte
-	tmpBytes, err = tmp.Bytes()
+	content, err := s.toHex(response[LSOF : len(response)-int(LEOF)])
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: Unable to decode hex pairs: %s", ErrFrame, err)
 	}
+	frame := make([]byte, 0, len(content)+flen)
+	frame = append(frame, response[:LSOF]...)
+	frame = append(frame, content...)
+	frame = append(frame, response[len(response)-int(LEOF):]...)
 
 	/*
 	 * The recover function should be able to handle the
 	 * message structure if the bytes have been converted from the ASCII
 	 * represetation to the binary.
 	 */
-	adu, err := s.Recover(tmpBytes, SASCII)
+	adu, err := s.Recover(frame, SASCII)
 	if err != nil {
 		return nil, err
 	}
 	return adu, nil
 }
+
+/*
+ * Assemble collects a complete ASCII frame in the transport buffer. The
+ * frame starts at the first colon and ends at the first CRLF after it,
+ * with characters expected within the ASCII inter-character timeout.
+ */
+func (s *ASCII) Assemble(t Transport) (int, error) {
+	return s.AssembleContext(context.Background(), t)
+}
+
+// AssembleContext collects a complete ASCII frame, giving up once the context is done
+func (s *ASCII) AssembleContext(ctx context.Context, t Transport) (int, error) {
+	receiver, ok := t.(Receiver)
+	if !ok {
+		return 0, fmt.Errorf("Transport unable to assemble ASCII frames: %T", t)
+	}
+	if !streamed(t) {
+		return 0, fmt.Errorf("ASCII frames over TCP require stream framing")
+	}
+	if err := t.ListenContext(ctx); err != nil {
+		return 0, err
+	}
+	for {
+		t.Lock()
+		buffer := t.Buffer()
+		// Discard any noise preceding the start of frame
+		end := -1
+		if start := bytes.IndexByte(buffer, COLON); start < 0 {
+			t.Flush()
+		} else {
+			t.Discard(start)
+			end = bytes.Index(t.Buffer(), []byte{CR, LF})
+		}
+		buffer = t.Buffer()
+		t.Unlock()
+		switch {
+		case end >= 0:
+			return end + int(LEOF), nil
+		case len(buffer) > MaxASCII:
+			return 0, fmt.Errorf("%w: Frame exceeds %v bytes", ErrFrame, MaxASCII)
+		}
+		if err := receiver.ReceiveContext(ctx, asciiTimeout); err != nil {
+			if ctx.Err() == nil && isTimeout(err) {
+				return 0, fmt.Errorf("%w: Frame incomplete at %v bytes", ErrFrame, len(buffer))
+			}
+			return 0, err
+		}
+	}
+}
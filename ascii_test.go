@@ -0,0 +1,95 @@
+package modbusd
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// asciiExchange is an exchange of ASCII frames, with | separating the fragments of the response
+func asciiExchange(request string, response string) exchange {
+	fragments := strings.Split(response, "|")
+	for idx, fragment := range fragments {
+		fragments[idx] = hex.EncodeToString([]byte(fragment))
+	}
+	return exchange{hex.EncodeToString([]byte(request)), strings.Join(fragments, "|")}
+}
+
+func TestASCIITyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		exchange exchange
+		call     typedCall
+		values   interface{}
+	}{
+		{
+			"ReadHoldingRegisters",
+			asciiExchange(":0103006B00038E\r\n", ":010306022B0000006465\r\n"),
+			func(c *Client) (interface{}, error) { return c.ReadHoldingRegisters(0x6B, 3) },
+			[]uint16{0x022B, 0x0000, 0x0064},
+		},
+		{
+			// Noise before the start of the frame is discarded and fragments are joined
+			"ReadCoils",
+			asciiExchange(":01010013000AE1\r\n", "\x00\xFF:0101|02CD01|2E\r|\n"),
+			func(c *Client) (interface{}, error) { return c.ReadCoils(0x13, 10) },
+			[]bool{true, false, true, true, false, false, true, true, true, false},
+		},
+		{
+			"WriteRegister",
+			asciiExchange(":010600010003F5\r\n", ":010600010003F5\r\n"),
+			func(c *Client) (interface{}, error) { return nil, c.WriteRegister(1, 3) },
+			nil,
+		},
+		{
+			"WriteRegisters",
+			asciiExchange(":01100001000204000A0102DB\r\n", ":011000010002EC\r\n"),
+			func(c *Client) (interface{}, error) { return nil, c.WriteRegisters(1, []uint16{0x000A, 0x0102}) },
+			nil,
+		},
+	}
+	protocol, _ := NewASCII(1)
+	c := fakeClient(t, protocol)
+	transport := c.Transport.(*fakeTransport)
+	for _, test := range tests {
+		transport.exchanges = append(transport.exchanges, test.exchange)
+	}
+	for _, test := range tests {
+		values, err := test.call(c)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if test.values != nil && !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s = %v; want %v", test.name, values, test.values)
+		}
+	}
+}
+
+func TestASCIIMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		err      error
+	}{
+		{"byte count beyond the frame", ":010304022BCB\r\n", ErrFrame},
+		{"byte count short of the frame", ":010302022B0000CD\r\n", ErrFrame},
+		{"odd number of digits", ":010302022B0CD\r\n", ErrFrame},
+		{"digit out of range", ":010302022G00CD\r\n", ErrFrame},
+		{"corrupt LRC", ":010302022BCC\r\n", ErrLRCMismatch},
+	}
+	for _, test := range tests {
+		protocol, _ := NewASCII(1)
+		c := fakeClient(t, protocol, asciiExchange(":010300000001FB\r\n", test.response))
+		if values, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, test.err) {
+			t.Errorf("ReadHoldingRegisters of a %s = %v, %v; want %v", test.name, values, err, test.err)
+		}
+	}
+	protocol, _ := NewASCII(1)
+	c := fakeClient(t, protocol, asciiExchange(":010300000001FB\r\n", ":0183027A\r\n"))
+	if _, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, IllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters of an exception = %v; want %v", err, IllegalDataAddress)
+	}
+}
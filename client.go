@@ -1,9 +1,13 @@
 package modbusd
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,15 +16,21 @@ type ClientType string
 // Known variations on the Modbus protocol that are supported
 const (
 	TCPCLIENT    ClientType = "TCPP" // TCP transport, MosbusTCP protocol
-	ASCIIOVERTCP ClientType = "AOTP" // TCP transport, ASCII protocol (TODO: currently not supported)
+	ASCIIOVERTCP ClientType = "AOTP" // TCP transport, ASCII protocol
 	RTUOVERTCP   ClientType = "ROTP" // TCP transport, RTU protocol
-	REMOTEUNIT   ClientType = "RTUP" // RTU transport, Serial protocol (TODO: currently not supported)
-	TEXT         ClientType = "ASCP" // ASCII transport, Serial protocol (TODO: currently not supported)
+	REMOTEUNIT   ClientType = "RTUP" // Serial transport, RTU protocol
+	TEXT         ClientType = "ASCP" // Serial transport, ASCII protocol
 )
 
 type Client struct {
 	Protocol  Protocol
 	Transport Transport
+	Order     Order // Byte and word order of the values of the device
+
+	Addressing Addressing // Convention of the absolute addresses given to ReadAbsolute and WriteAbsolute
+
+	m   sync.Mutex
+	mux *Mux
 }
 
 // NewClient creates an instance of the Client class
@@ -42,112 +52,221 @@ func NewClient(u *URL) (*Client, error) {
 			return nil, fmt.Errorf("Unable to create protocol: %s", err)
 		}
 	case RTUOVERTCP:
-		// Assign a TCP transport and a RTU protocol
-		if transport, err = NewTCP(u.IP, u.PortNo, time.Duration(u.Timeout)*time.Second); err != nil {
+		// Assign a TCP transport and a RTU protocol, delimiting frames by their expected length
+		var tcp *TCP
+		if tcp, err = NewTCP(u.IP, u.PortNo, time.Duration(u.Timeout)*time.Second); err != nil {
 			return nil, fmt.Errorf("Unable to create transport: %s", err)
 		}
+		tcp.Framing = FramingStream
+		transport = tcp
 		if protocol, err = NewRTU(u.SlaveId); err != nil {
 			return nil, fmt.Errorf("Unable to create protocol: %s", err)
 		}
 	case ASCIIOVERTCP:
-		// TODO: Implement
+		// Assign a TCP transport and an ASCII protocol, delimiting frames by their trailer
+		var tcp *TCP
+		if tcp, err = NewTCP(u.IP, u.PortNo, time.Duration(u.Timeout)*time.Second); err != nil {
+			return nil, fmt.Errorf("Unable to create transport: %s", err)
+		}
+		tcp.Framing = FramingStream
+		transport = tcp
+		if protocol, err = NewASCII(u.SlaveId); err != nil {
+			return nil, fmt.Errorf("Unable to create protocol: %s", err)
+		}
 	case REMOTEUNIT:
-		// TODO: Implement
+		// Assign a Serial transport and a RTU protocol
+		if transport, err = newSerial(u); err != nil {
+			return nil, fmt.Errorf("Unable to create transport: %s", err)
+		}
+		var rtu *RTU
+		if rtu, err = NewRTU(u.SlaveId); err != nil {
+			return nil, fmt.Errorf("Unable to create protocol: %s", err)
+		}
+		rtu.Silence = u.Delay
+		protocol = rtu
 	case TEXT:
-		// TODO: Implement
-
+		// Assign a Serial transport and an ASCII protocol
+		if transport, err = newSerial(u); err != nil {
+			return nil, fmt.Errorf("Unable to create transport: %s", err)
+		}
+		if protocol, err = NewASCII(u.SlaveId); err != nil {
+			return nil, fmt.Errorf("Unable to create protocol: %s", err)
+		}
 	default:
 		// Impossible to establish client without transport and protocol definition
 		return nil, fmt.Errorf("Unknown client type %s", ClientType(u.Protocol))
 	}
+	if tcp, ok := transport.(*TCP); ok {
+		tcp.Retries = u.Retries
+	}
 	return &Client{
 		Protocol:  protocol,
 		Transport: transport,
+		Order:     u.Order,
+
+		Addressing: u.Addressing,
 	}, nil
 
 }
 
-func (c *Client) do(request *ADU) (*ADU, error) {
+// newSerial creates a Serial transport from the device and line settings in the URL
+func newSerial(u *URL) (*Serial, error) {
+	serial, err := NewSerial(u.Device, u.Baud)
+	if err != nil {
+		return nil, err
+	}
+	serial.DataBits = u.DataBits
+	serial.Parity = u.Parity
+	serial.StopBits = u.StopBits
+	if u.Timeout > 0 {
+		serial.Timeout = time.Duration(u.Timeout) * time.Second
+	}
+	return serial, nil
+}
+
+func (c *Client) do(ctx context.Context, request *ADU) (*ADU, error) {
 	var err error
 	var response *ADU
 	// Send a request encoded by client protocol
-	if err = c.Transport.Send(request); err != nil {
-		return nil, fmt.Errorf("Request failed: %s", err)
-	}
-	// Listen for a response in client protocol
-	if err := c.Transport.Listen(nil); err != nil {
-		if strings.Contains(err.Error(), "timeout") {
-			var pdu *PDU
-			pdu, err = NewPDU(FERR)
-			if err != nil {
-				return nil, fmt.Errorf("Transport unable to listen: %s", err)
+	if err = c.Transport.SendContext(ctx, request); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("Request failed: %w", err)
+	}
+	/*
+	 * Listen for a response in client protocol, discarding responses to
+	 * other requests until the matching response arrives or the transport
+	 * times out.
+	 */
+	for {
+		var length int
+		if assembler, ok := c.Protocol.(Assembler); ok {
+			length, err = assembler.AssembleContext(ctx, c.Transport)
+		} else {
+			err = c.Transport.ListenContext(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// Cancellation is reported as such rather than as a timeout of the device
+				return nil, ctx.Err()
 			}
-			response, err = NewADU(pdu)
-			if err != nil {
-				return nil, fmt.Errorf("Transport unable to listen: %s", err)
+			if isTimeout(err) {
+				return nil, fmt.Errorf("%w: %s", ErrTimeout, err)
 			}
-			response.Timeout = true
-			return response, nil
+			return nil, fmt.Errorf("Transport unable to listen: %w", err)
+		}
+
+		/*
+		 * Lock access to response buffer.
+		 * Although not critical in command/response based communications,
+		 * it is an important construct if any asynchronuous communications
+		 * is employed.
+		 */
+		c.Transport.Lock()
+		buffer := c.Transport.Buffer()
+		if length > 0 {
+			// Only the assembled frame is decoded, any further bytes belong to the next frame
+			buffer = buffer[:length]
+		}
+		response, err = c.Protocol.Decode(buffer)
+		// Clear buffer to avoid procesing the same data packet more than once
+		if _, stream := c.Protocol.(*ModbusTCP); stream && length > 0 {
+			// Bytes following a ModbusTCP frame belong to the next frame
+			c.Transport.Discard(length)
 		} else {
-			return nil, fmt.Errorf("Transport unable to listen: %s", err)
+			c.Transport.Flush()
+		}
+		c.Transport.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode response: %w", err)
 		}
-	}
 
-	/*
-	 * Lock access to response buffer.
-	 * Although not critical in command/response based communications,
-	 * it is an important construct if any asynchronuous communications
-	 * is employed.
-	 */
-	c.Transport.Lock()
-	response, err = c.Protocol.Decode(c.Transport.Buffer())
-	if err != nil {
-		return nil, fmt.Errorf("Unable to decode response: %s", err)
+		if matcher, ok := c.Protocol.(Matcher); ok {
+			var matched bool
+			if matched, err = matcher.Match(request, response); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrResponse, err)
+			}
+			if !matched {
+				// A stale response to an earlier request
+				continue
+			}
+		}
+		return response, nil
 	}
-	// Clear buffer to avoid procesing the same data packet more than once
-	c.Transport.Flush()
-	c.Transport.Unlock()
-	return response, nil
 }
 
 // Request connects to the modbus device, fires of a request and interprets the result
 func (c *Client) Request(url *URL) (*ADU, error) {
+	return c.RequestContext(context.Background(), url)
+}
+
+// RequestContext is Request, abandoned as soon as the context is done
+func (c *Client) RequestContext(ctx context.Context, url *URL) (*ADU, error) {
 	var err error
 	if c == nil {
 		return nil, fmt.Errorf("Illegal client")
 	}
 
-	if err := c.Transport.Connect(); err != nil {
+	if err := c.Transport.ConnectContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("Client connection failed: %s", err)
 	}
 
 	var response *ADU
-	response, err = c.Read(url)
+	response, err = c.ReadContext(ctx, url)
+	c.Close()
 	if err != nil {
-		return nil, fmt.Errorf("%s", err)
+		return nil, err
 	}
-	c.Close()
 	return response, nil
 
 }
 
+/*
+ * Read executes the request addressed by the URL, reporting an exception
+ * response as an ExceptionError. A URL with values writes them from the
+ * address instead, to coils or holding registers depending on the address.
+ */
 func (c *Client) Read(url *URL) (*ADU, error) {
+	return c.ReadContext(context.Background(), url)
+}
+
+// ReadContext is Read, abandoned as soon as the context is done
+func (c *Client) ReadContext(ctx context.Context, url *URL) (*ADU, error) {
 	var err error
 	if c == nil {
 		return nil, fmt.Errorf("Illegal client")
 	}
 
 	var request *Request
-	var pdu *PDU
-	var adu *ADU
 	// Create a modbus request without any protocol encoding
+	if len(url.Values) > 0 {
+		if request, err = NewWriteRequest(strconv.FormatUint(url.Address, 10), url.Values); err != nil {
+			return nil, fmt.Errorf("Unable to create modbus request: %s", err)
+		}
+		return c.write(ctx, request)
+	}
 	if request, err = NewRequest(FERR, strconv.FormatUint(url.Address, 10), url.Quantity); err != nil {
 		return nil, fmt.Errorf("Unable to create modbus request: %s", err)
 	}
-	// Encode modbus request, still without protocol encoding
-	if pdu, err = request.Encode(); err != nil {
-		return nil, fmt.Errorf("Unable to encode modbus request: %#v. %s", request, err)
+	return c.execute(ctx, request)
+}
+
+// transact encodes a modbus request PDU in the client protocol and executes it on the transport
+func (c *Client) transact(ctx context.Context, pdu *PDU) (*ADU, error) {
+	var err error
+	var adu *ADU
+	c.m.Lock()
+	if mux := c.mux; mux != nil {
+		// Concurrent transactions are correlated by the multiplexer
+		c.m.Unlock()
+		return mux.do(ctx, pdu)
 	}
+	// A single transaction at a time is outstanding on the transport
+	defer c.m.Unlock()
 	// Encode request in the assigned client protocol
 	if adu, err = c.Protocol.Encode(pdu); err != nil {
 		return nil, fmt.Errorf("Unable to encode the PDU: %#v, %s", pdu, err)
@@ -155,18 +274,855 @@ func (c *Client) Read(url *URL) (*ADU, error) {
 
 	// Execute the request on a connection to the device identified by the transport
 	var response *ADU
-	response, err = c.do(adu)
+	response, err = c.do(ctx, adu)
 	if err != nil {
 		c.Transport.Lock()
 		c.Transport.Flush()
 		c.Transport.Unlock()
-		return nil, fmt.Errorf("%s", err)
+		return nil, err
+	}
+	return response, nil
+}
+
+// execute runs a modbus request and converts exception responses to an ExceptionError
+func (c *Client) execute(ctx context.Context, request *Request) (*ADU, error) {
+	// Encode modbus request, still without protocol encoding
+	pdu, err := request.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode modbus request: %#v. %s", request, err)
+	}
+	return c.executePDU(ctx, pdu)
+}
+
+// executePDU runs an encoded modbus request and converts exception responses to an ExceptionError
+func (c *Client) executePDU(ctx context.Context, pdu *PDU) (*ADU, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Illegal client")
+	}
+	response, err := c.transact(ctx, pdu)
+	if err != nil {
+		return nil, err
+	}
+	requested := FnCode(pdu.FnCode[0])
+	fncode := FnCode(response.FnCode[0])
+	if fncode >= FERR {
+		return nil, &ExceptionError{FnCode: requested, ExCode: response.ExceptionCode}
+	}
+	if fncode != requested {
+		return nil, fmt.Errorf("%w: Function code mismatch %v!=%v", ErrResponse, fncode, requested)
+	}
+	return response, nil
+}
+
+// bits runs a coil or discrete input read and unpacks the returned bits
+func (c *Client) bits(ctx context.Context, fncode FnCode, address uint16, quantity uint16) ([]bool, error) {
+	if quantity < 1 || quantity > MaxReadBits {
+		return nil, fmt.Errorf("Illegal quantity of bits: %v", quantity)
+	}
+	response, err := c.execute(ctx, &Request{
+		FnCode:   fncode,
+		Address:  address,
+		Quantity: quantity,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Data[0] holds the byte count followed by the bits packed eight to a byte
+	count := int(quantity+7) / 8
+	if len(response.Data) < 1 || int(response.Data[0]) != count || len(response.Data) != count+1 {
+		return nil, fmt.Errorf("%w: Illegal byte count for %v bits: %v", ErrResponse, quantity, response.Data)
+	}
+	return unpackBits(response.Data[1:], int(quantity)), nil
+}
+
+// registers runs a holding or input register read and decodes the big endian words
+func (c *Client) registers(ctx context.Context, fncode FnCode, address uint16, quantity uint16) ([]uint16, error) {
+	if quantity < 1 || quantity > MaxReadRegisters {
+		return nil, fmt.Errorf("Illegal quantity of registers: %v", quantity)
+	}
+	response, err := c.execute(ctx, &Request{
+		FnCode:   fncode,
+		Address:  address,
+		Quantity: quantity,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registerResponse(response, quantity)
+}
+
+// registerResponse decodes the registers returned by a read, checking the byte count against the quantity
+func registerResponse(response *ADU, quantity uint16) ([]uint16, error) {
+	// Data[0] holds the byte count followed by two bytes per register
+	count := int(quantity) * 2
+	if len(response.Data) < 1 || int(response.Data[0]) != count || len(response.Data) != count+1 {
+		return nil, fmt.Errorf("%w: Illegal byte count for %v registers: %v", ErrResponse, quantity, response.Data)
+	}
+	return registerValues(response.Data[1:]), nil
+}
+
+// ReadCoils reads consecutive coils starting at the relative address
+func (c *Client) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	return c.ReadCoilsContext(context.Background(), address, quantity)
+}
+
+// ReadCoilsContext is ReadCoils, abandoned as soon as the context is done
+func (c *Client) ReadCoilsContext(ctx context.Context, address uint16, quantity uint16) ([]bool, error) {
+	return c.bits(ctx, RDCO, address, quantity)
+}
+
+// ReadDiscreteInputs reads consecutive discrete inputs starting at the relative address
+func (c *Client) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	return c.ReadDiscreteInputsContext(context.Background(), address, quantity)
+}
+
+// ReadDiscreteInputsContext is ReadDiscreteInputs, abandoned as soon as the context is done
+func (c *Client) ReadDiscreteInputsContext(ctx context.Context, address uint16, quantity uint16) ([]bool, error) {
+	return c.bits(ctx, RDDI, address, quantity)
+}
+
+// ReadHoldingRegisters reads consecutive holding registers starting at the relative address
+func (c *Client) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.ReadHoldingRegistersContext(context.Background(), address, quantity)
+}
+
+// ReadHoldingRegistersContext is ReadHoldingRegisters, abandoned as soon as the context is done
+func (c *Client) ReadHoldingRegistersContext(ctx context.Context, address uint16, quantity uint16) ([]uint16, error) {
+	return c.registers(ctx, RDHR, address, quantity)
+}
+
+// ReadInputRegisters reads consecutive input registers starting at the relative address
+func (c *Client) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.ReadInputRegistersContext(context.Background(), address, quantity)
+}
+
+// ReadInputRegistersContext is ReadInputRegisters, abandoned as soon as the context is done
+func (c *Client) ReadInputRegistersContext(ctx context.Context, address uint16, quantity uint16) ([]uint16, error) {
+	return c.registers(ctx, RDIR, address, quantity)
+}
+
+// write runs a write request and verifies that the response repeats the address and value or quantity
+func (c *Client) write(ctx context.Context, request *Request) (*ADU, error) {
+	value := request.Quantity
+	switch request.FnCode {
+	case WRSC:
+		value = CoilOff
+		if len(request.Values) > 0 && request.Values[0] != 0 {
+			value = CoilOn
+		}
+	case WRSR:
+		if len(request.Values) > 0 {
+			value = request.Values[0]
+		}
+	}
+	response, err := c.execute(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) != 4 {
+		return nil, fmt.Errorf("%w: Illegal write response length: %v", ErrResponse, len(response.Data))
+	}
+	address := binary.BigEndian.Uint16(response.Data)
+	echoed := binary.BigEndian.Uint16(response.Data[2:])
+	if address != request.Address || echoed != value {
+		return nil, fmt.Errorf("%w: Write response mismatch %v:%v!=%v:%v", ErrResponse, address, echoed, request.Address, value)
 	}
 	return response, nil
+}
+
+// WriteCoil sets a single coil at the relative address
+func (c *Client) WriteCoil(address uint16, value bool) error {
+	return c.WriteCoilContext(context.Background(), address, value)
+}
+
+// WriteCoilContext is WriteCoil, abandoned as soon as the context is done
+func (c *Client) WriteCoilContext(ctx context.Context, address uint16, value bool) error {
+	request := &Request{
+		FnCode:   WRSC,
+		Address:  address,
+		Quantity: 1,
+		Values:   []uint16{CoilOff},
+	}
+	if value {
+		request.Values[0] = CoilOn
+	}
+	_, err := c.write(ctx, request)
+	return err
+}
+
+// WriteRegister sets a single holding register at the relative address
+func (c *Client) WriteRegister(address uint16, value uint16) error {
+	return c.WriteRegisterContext(context.Background(), address, value)
+}
+
+// WriteRegisterContext is WriteRegister, abandoned as soon as the context is done
+func (c *Client) WriteRegisterContext(ctx context.Context, address uint16, value uint16) error {
+	request := &Request{
+		FnCode:   WRSR,
+		Address:  address,
+		Quantity: 1,
+		Values:   []uint16{value},
+	}
+	_, err := c.write(ctx, request)
+	return err
+}
+
+// WriteCoils sets consecutive coils starting at the relative address
+func (c *Client) WriteCoils(address uint16, values []bool) error {
+	return c.WriteCoilsContext(context.Background(), address, values)
+}
+
+// WriteCoilsContext is WriteCoils, abandoned as soon as the context is done
+func (c *Client) WriteCoilsContext(ctx context.Context, address uint16, values []bool) error {
+	request := &Request{
+		FnCode:   WRMC,
+		Address:  address,
+		Quantity: uint16(len(values)),
+		Values:   make([]uint16, len(values)),
+	}
+	for idx, value := range values {
+		if value {
+			request.Values[idx] = 1
+		}
+	}
+	_, err := c.write(ctx, request)
+	return err
+}
+
+// WriteRegisters sets consecutive holding registers starting at the relative address
+func (c *Client) WriteRegisters(address uint16, values []uint16) error {
+	return c.WriteRegistersContext(context.Background(), address, values)
+}
+
+// WriteRegistersContext is WriteRegisters, abandoned as soon as the context is done
+func (c *Client) WriteRegistersContext(ctx context.Context, address uint16, values []uint16) error {
+	request := &Request{
+		FnCode:   WRMR,
+		Address:  address,
+		Quantity: uint16(len(values)),
+		Values:   values,
+	}
+	_, err := c.write(ctx, request)
+	return err
+}
+
+/*
+ * ReadAbsolute reads the quantity of items at an absolute address in the
+ * addressing of the client, such as 40001 for OneBased5 or hr:0 for Prefixed.
+ * Coils and discrete inputs are returned as 1 or 0.
+ */
+func (c *Client) ReadAbsolute(address string, quantity uint16) ([]uint16, error) {
+	return c.ReadAbsoluteContext(context.Background(), address, quantity)
+}
+
+// ReadAbsoluteContext is ReadAbsolute, abandoned as soon as the context is done
+func (c *Client) ReadAbsoluteContext(ctx context.Context, address string, quantity uint16) ([]uint16, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Illegal client")
+	}
+	var fncode FnCode
+	relative, err := c.Addressing.Relative(address, &fncode)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map address to function code: %s", err)
+	}
+	switch fncode {
+	case RDCO, RDDI:
+		bits, err := c.bits(ctx, fncode, relative, quantity)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]uint16, len(bits))
+		for idx, bit := range bits {
+			if bit {
+				values[idx] = 1
+			}
+		}
+		return values, nil
+	default:
+		return c.registers(ctx, fncode, relative, quantity)
+	}
+}
+
+/*
+ * WriteAbsolute writes the values from an absolute address of coils or
+ * holding registers in the addressing of the client. Coils are set by non
+ * zero values, and a single value is written with the single write function
+ * code.
+ */
+func (c *Client) WriteAbsolute(address string, values []uint16) error {
+	return c.WriteAbsoluteContext(context.Background(), address, values)
+}
+
+// WriteAbsoluteContext is WriteAbsolute, abandoned as soon as the context is done
+func (c *Client) WriteAbsoluteContext(ctx context.Context, address string, values []uint16) error {
+	if c == nil {
+		return fmt.Errorf("Illegal client")
+	}
+	if len(values) < 1 || len(values) > 0xFFFF {
+		return fmt.Errorf("Illegal number of values: %v", len(values))
+	}
+	var fncode FnCode
+	relative, err := c.Addressing.RelativeWrite(address, uint16(len(values)), &fncode)
+	if err != nil {
+		return fmt.Errorf("Unable to map address to function code: %s", err)
+	}
+	_, err = c.write(ctx, &Request{
+		FnCode:   fncode,
+		Address:  relative,
+		Quantity: uint16(len(values)),
+		Values:   values,
+	})
+	return err
+}
+
+// MaskWriteRegister modifies a holding register to (value AND and) OR (or AND NOT and)
+func (c *Client) MaskWriteRegister(address uint16, and uint16, or uint16) error {
+	return c.MaskWriteRegisterContext(context.Background(), address, and, or)
+}
+
+// MaskWriteRegisterContext is MaskWriteRegister, abandoned as soon as the context is done
+func (c *Client) MaskWriteRegisterContext(ctx context.Context, address uint16, and uint16, or uint16) error {
+	request := &Request{
+		FnCode:  MWRR,
+		Address: address,
+		ANDMask: and,
+		ORMask:  or,
+	}
+	response, err := c.execute(ctx, request)
+	if err != nil {
+		return err
+	}
+	// The response echoes the address and both masks
+	if len(response.Data) != 6 {
+		return fmt.Errorf("%w: Illegal mask write response length: %v", ErrResponse, len(response.Data))
+	}
+	echoed := []uint16{
+		binary.BigEndian.Uint16(response.Data),
+		binary.BigEndian.Uint16(response.Data[2:]),
+		binary.BigEndian.Uint16(response.Data[4:]),
+	}
+	if echoed[0] != address || echoed[1] != and || echoed[2] != or {
+		return fmt.Errorf("%w: Mask write response mismatch %v!=%v", ErrResponse, echoed, []uint16{address, and, or})
+	}
+	return nil
+}
+
+/*
+ * ReadWriteRegisters writes the values to consecutive holding registers from
+ * the write address and then reads the quantity of holding registers from the
+ * read address, in a single transaction.
+ */
+func (c *Client) ReadWriteRegisters(readAddress uint16, quantity uint16, writeAddress uint16, values []uint16) ([]uint16, error) {
+	return c.ReadWriteRegistersContext(context.Background(), readAddress, quantity, writeAddress, values)
+}
+
+// ReadWriteRegistersContext is ReadWriteRegisters, abandoned as soon as the context is done
+func (c *Client) ReadWriteRegistersContext(ctx context.Context, readAddress uint16, quantity uint16, writeAddress uint16, values []uint16) ([]uint16, error) {
+	if quantity < 1 || quantity > MaxReadRegisters {
+		return nil, fmt.Errorf("Illegal quantity of registers: %v", quantity)
+	}
+	response, err := c.execute(ctx, &Request{
+		FnCode:       RWMR,
+		Address:      readAddress,
+		Quantity:     quantity,
+		WriteAddress: writeAddress,
+		Values:       values,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registerResponse(response, quantity)
+}
+
+/*
+ * ReadFIFOQueue reads the queue of holding registers behind the FIFO pointer
+ * address, of up to 31 values. The count of queued values is returned by the
+ * device ahead of the values themselves.
+ */
+func (c *Client) ReadFIFOQueue(address uint16) ([]uint16, error) {
+	return c.ReadFIFOQueueContext(context.Background(), address)
+}
+
+// ReadFIFOQueueContext is ReadFIFOQueue, abandoned as soon as the context is done
+func (c *Client) ReadFIFOQueueContext(ctx context.Context, address uint16) ([]uint16, error) {
+	response, err := c.execute(ctx, &Request{
+		FnCode:  FIFO,
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Data[0:2] holds the byte count and Data[2:4] the FIFO count, followed by two bytes per value
+	if len(response.Data) < 4 {
+		return nil, fmt.Errorf("%w: Illegal FIFO response length: %v", ErrResponse, len(response.Data))
+	}
+	count := binary.BigEndian.Uint16(response.Data)
+	fifo := binary.BigEndian.Uint16(response.Data[2:])
+	if fifo > MaxFIFOCount {
+		return nil, fmt.Errorf("%w: Illegal FIFO count: %v", ErrResponse, fifo)
+	}
+	if int(count) != 2+2*int(fifo) || len(response.Data) != 2+int(count) {
+		return nil, fmt.Errorf("%w: Illegal byte count for %v FIFO values: %v", ErrResponse, fifo, response.Data)
+	}
+	return registerValues(response.Data[4:]), nil
+}
+
+/*
+ * ReadFileRecords reads the registers of each file record sub-request in a
+ * single transaction, returning the records with their Values filled in.
+ */
+func (c *Client) ReadFileRecords(records []FileRecord) ([]FileRecord, error) {
+	return c.ReadFileRecordsContext(context.Background(), records)
+}
+
+// ReadFileRecordsContext is ReadFileRecords, abandoned as soon as the context is done
+func (c *Client) ReadFileRecordsContext(ctx context.Context, records []FileRecord) ([]FileRecord, error) {
+	response, err := c.execute(ctx, &Request{
+		FnCode:  RDFR,
+		Records: records,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Data[0] holds the byte count followed by the length, reference type and registers of each sub-request
+	if len(response.Data) < 1 || len(response.Data) != 1+int(response.Data[0]) {
+		return nil, fmt.Errorf("%w: Illegal file record response length: %v", ErrResponse, response.Data)
+	}
+	result := make([]FileRecord, len(records))
+	data := response.Data[1:]
+	for idx, record := range records {
+		length := 1 + 2*int(record.Length)
+		if len(data) < 1+length || int(data[0]) != length || data[1] != FileReferenceType {
+			return nil, fmt.Errorf("%w: Illegal file record sub-response %v: %v", ErrResponse, idx, data)
+		}
+		result[idx] = FileRecord{
+			File:   record.File,
+			Record: record.Record,
+			Length: record.Length,
+			Values: registerValues(data[2 : 1+length]),
+		}
+		data = data[1+length:]
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%w: Unexpected file record data: %v", ErrResponse, data)
+	}
+	return result, nil
+}
+
+// ReadFileRecord reads length registers from a record number within a file
+func (c *Client) ReadFileRecord(file uint16, record uint16, length uint16) ([]uint16, error) {
+	return c.ReadFileRecordContext(context.Background(), file, record, length)
+}
+
+// ReadFileRecordContext is ReadFileRecord, abandoned as soon as the context is done
+func (c *Client) ReadFileRecordContext(ctx context.Context, file uint16, record uint16, length uint16) ([]uint16, error) {
+	records, err := c.ReadFileRecordsContext(ctx, []FileRecord{{File: file, Record: record, Length: length}})
+	if err != nil {
+		return nil, err
+	}
+	return records[0].Values, nil
+}
+
+// WriteFileRecords writes the values of each file record sub-request in a single transaction
+func (c *Client) WriteFileRecords(records []FileRecord) error {
+	return c.WriteFileRecordsContext(context.Background(), records)
+}
+
+// WriteFileRecordsContext is WriteFileRecords, abandoned as soon as the context is done
+func (c *Client) WriteFileRecordsContext(ctx context.Context, records []FileRecord) error {
+	request := &Request{
+		FnCode:  WRFR,
+		Records: records,
+	}
+	pdu, err := request.Encode()
+	if err != nil {
+		return fmt.Errorf("Unable to encode modbus request: %#v. %s", request, err)
+	}
+	response, err := c.executePDU(ctx, pdu)
+	if err != nil {
+		return err
+	}
+	// The response echoes the request
+	if !bytes.Equal(response.Data, pdu.Data) {
+		return fmt.Errorf("%w: File record write response mismatch %v!=%v", ErrResponse, response.Data, pdu.Data)
+	}
+	return nil
+}
+
+// WriteFileRecord writes the values from a record number within a file
+func (c *Client) WriteFileRecord(file uint16, record uint16, values []uint16) error {
+	return c.WriteFileRecordContext(context.Background(), file, record, values)
+}
+
+// WriteFileRecordContext is WriteFileRecord, abandoned as soon as the context is done
+func (c *Client) WriteFileRecordContext(ctx context.Context, file uint16, record uint16, values []uint16) error {
+	return c.WriteFileRecordsContext(ctx, []FileRecord{{File: file, Record: record, Values: values}})
+}
+
+/*
+ * ReadDeviceIdentification reads the identification objects of the device
+ * for the access code, starting from the object id. Stream access requests
+ * are repeated while the device reports that more objects follow, so the
+ * returned map holds all the objects of the category.
+ */
+func (c *Client) ReadDeviceIdentification(code DeviceIdCode, object ObjectId) (map[ObjectId]string, error) {
+	return c.ReadDeviceIdentificationContext(context.Background(), code, object)
+}
+
+// ReadDeviceIdentificationContext is ReadDeviceIdentification, abandoned as soon as the context is done
+func (c *Client) ReadDeviceIdentificationContext(ctx context.Context, code DeviceIdCode, object ObjectId) (map[ObjectId]string, error) {
+	objects := make(map[ObjectId]string)
+	for {
+		response, err := c.execute(ctx, &Request{
+			FnCode:    MEIT,
+			MEIType:   MEIDeviceId,
+			ReadDevId: code,
+			ObjectId:  object,
+		})
+		if err != nil {
+			return nil, err
+		}
+		id, err := NewDeviceIdentification(response.Data)
+		if err != nil {
+			return nil, err
+		}
+		if id.Code != code {
+			return nil, fmt.Errorf("%w: Read device id code mismatch %v!=%v", ErrResponse, id.Code, code)
+		}
+		collected := len(objects)
+		for key, value := range id.Objects {
+			objects[key] = value
+		}
+		if !id.MoreFollows {
+			return objects, nil
+		}
+		// Continue from the next object, as long as each response contributes new objects
+		if _, found := objects[id.NextObjectId]; found || code == IndividualDeviceId || len(objects) == collected {
+			return nil, fmt.Errorf("%w: Illegal continuation at object %v", ErrResponse, id.NextObjectId)
+		}
+		object = id.NextObjectId
+	}
+}
+
+// ReadExceptionStatus reads the eight exception status outputs of a serial device
+func (c *Client) ReadExceptionStatus() (byte, error) {
+	return c.ReadExceptionStatusContext(context.Background())
+}
+
+// ReadExceptionStatusContext is ReadExceptionStatus, abandoned as soon as the context is done
+func (c *Client) ReadExceptionStatusContext(ctx context.Context) (byte, error) {
+	response, err := c.execute(ctx, &Request{FnCode: RDES})
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Data) != 1 {
+		return 0, fmt.Errorf("%w: Illegal exception status length: %v", ErrResponse, len(response.Data))
+	}
+	return response.Data[0], nil
+}
+
+/*
+ * Diagnostic runs a sub-function of the serial line diagnostics with its
+ * data, a single word for all but ReturnQueryData, and returns the data of
+ * the response after verifying that it echoes the sub-function.
+ */
+func (c *Client) Diagnostic(subfn uint16, data []uint16) ([]uint16, error) {
+	return c.DiagnosticContext(context.Background(), subfn, data)
+}
+
+// DiagnosticContext is Diagnostic, abandoned as soon as the context is done
+func (c *Client) DiagnosticContext(ctx context.Context, subfn uint16, data []uint16) ([]uint16, error) {
+	if subfn == ForceListenOnlyMode {
+		return nil, fmt.Errorf("Diagnostic sub-function is not answered by the device: %v", subfn)
+	}
+	response, err := c.execute(ctx, &Request{
+		FnCode: DIAG,
+		SubFn:  subfn,
+		Values: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) < 2 || len(response.Data)%2 != 0 {
+		return nil, fmt.Errorf("%w: Illegal diagnostic response length: %v", ErrResponse, len(response.Data))
+	}
+	if echoed := binary.BigEndian.Uint16(response.Data); echoed != subfn {
+		return nil, fmt.Errorf("%w: Diagnostic sub-function mismatch %v!=%v", ErrResponse, echoed, subfn)
+	}
+	return registerValues(response.Data[2:]), nil
+}
+
+// diagnosticWord runs a sub-function with a single word of data and returns the single word of the response
+func (c *Client) diagnosticWord(ctx context.Context, subfn uint16, value uint16) (uint16, error) {
+	data, err := c.DiagnosticContext(ctx, subfn, []uint16{value})
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 1 {
+		return 0, fmt.Errorf("%w: Illegal diagnostic data for sub-function %v: %v", ErrResponse, subfn, data)
+	}
+	return data[0], nil
+}
+
+// diagnosticEcho runs a sub-function with a single word of data and verifies that the response echoes it
+func (c *Client) diagnosticEcho(ctx context.Context, subfn uint16, value uint16) error {
+	echoed, err := c.diagnosticWord(ctx, subfn, value)
+	if err != nil {
+		return err
+	}
+	if echoed != value {
+		return fmt.Errorf("%w: Diagnostic data mismatch %v!=%v", ErrResponse, echoed, value)
+	}
+	return nil
+}
+
+// ReturnQueryData loops the data back through the device and verifies that it is returned unchanged
+func (c *Client) ReturnQueryData(data []uint16) error {
+	return c.ReturnQueryDataContext(context.Background(), data)
+}
+
+// ReturnQueryDataContext is ReturnQueryData, abandoned as soon as the context is done
+func (c *Client) ReturnQueryDataContext(ctx context.Context, data []uint16) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Illegal quantity of query data: %v", len(data))
+	}
+	echoed, err := c.DiagnosticContext(ctx, ReturnQueryData, data)
+	if err != nil {
+		return err
+	}
+	if len(echoed) != len(data) {
+		return fmt.Errorf("%w: Query data mismatch %v!=%v", ErrResponse, echoed, data)
+	}
+	for idx := range data {
+		if echoed[idx] != data[idx] {
+			return fmt.Errorf("%w: Query data mismatch %v!=%v", ErrResponse, echoed, data)
+		}
+	}
+	return nil
+}
+
+// RestartCommunications restarts the serial port of the device, also clearing its comm event log if requested
+func (c *Client) RestartCommunications(clearLog bool) error {
+	return c.RestartCommunicationsContext(context.Background(), clearLog)
+}
+
+// RestartCommunicationsContext is RestartCommunications, abandoned as soon as the context is done
+func (c *Client) RestartCommunicationsContext(ctx context.Context, clearLog bool) error {
+	value := uint16(0x0000)
+	if clearLog {
+		value = 0xFF00
+	}
+	return c.diagnosticEcho(ctx, RestartCommunicationsOption, value)
+}
+
+// ReadDiagnosticRegister reads the 16-bit diagnostic register of the device
+func (c *Client) ReadDiagnosticRegister() (uint16, error) {
+	return c.ReadDiagnosticRegisterContext(context.Background())
+}
+
+// ReadDiagnosticRegisterContext is ReadDiagnosticRegister, abandoned as soon as the context is done
+func (c *Client) ReadDiagnosticRegisterContext(ctx context.Context) (uint16, error) {
+	return c.diagnosticWord(ctx, ReturnDiagnosticRegister, 0)
+}
+
+// ChangeASCIIDelimiter replaces the line feed that ends ASCII requests to the device by the delimiter
+func (c *Client) ChangeASCIIDelimiter(delimiter byte) error {
+	return c.ChangeASCIIDelimiterContext(context.Background(), delimiter)
+}
 
+// ChangeASCIIDelimiterContext is ChangeASCIIDelimiter, abandoned as soon as the context is done
+func (c *Client) ChangeASCIIDelimiterContext(ctx context.Context, delimiter byte) error {
+	return c.diagnosticEcho(ctx, ChangeASCIIInputDelimiter, uint16(delimiter)<<8)
+}
+
+// ClearCounters clears the diagnostic counters and register of the device
+func (c *Client) ClearCounters() error {
+	return c.ClearCountersContext(context.Background())
+}
+
+// ClearCountersContext is ClearCounters, abandoned as soon as the context is done
+func (c *Client) ClearCountersContext(ctx context.Context) error {
+	return c.diagnosticEcho(ctx, ClearCountersAndDiagnostic, 0)
+}
+
+// ClearOverrunCounter clears the character overrun counter and error flag of the device
+func (c *Client) ClearOverrunCounter() error {
+	return c.ClearOverrunCounterContext(context.Background())
+}
+
+// ClearOverrunCounterContext is ClearOverrunCounter, abandoned as soon as the context is done
+func (c *Client) ClearOverrunCounterContext(ctx context.Context) error {
+	return c.diagnosticEcho(ctx, ClearOverrunCounterAndFlag, 0)
+}
+
+// DiagnosticCounter reads the counter of one of the Return...Count sub-functions
+func (c *Client) DiagnosticCounter(subfn uint16) (uint16, error) {
+	return c.DiagnosticCounterContext(context.Background(), subfn)
+}
+
+// DiagnosticCounterContext is DiagnosticCounter, abandoned as soon as the context is done
+func (c *Client) DiagnosticCounterContext(ctx context.Context, subfn uint16) (uint16, error) {
+	if subfn < ReturnBusMessageCount || subfn > ReturnBusCharacterOverrunCount {
+		return 0, fmt.Errorf("Not a diagnostic counter sub-function: %v", subfn)
+	}
+	return c.diagnosticWord(ctx, subfn, 0)
+}
+
+// DiagnosticCounters reads all the diagnostic counters of the device, one request each
+func (c *Client) DiagnosticCounters() (*DiagnosticCounters, error) {
+	return c.DiagnosticCountersContext(context.Background())
+}
+
+// DiagnosticCountersContext is DiagnosticCounters, abandoned as soon as the context is done
+func (c *Client) DiagnosticCountersContext(ctx context.Context) (*DiagnosticCounters, error) {
+	counters := &DiagnosticCounters{}
+	/*
+	 * Each request is itself counted by the device, so the counters are read
+	 * in the order of their sub-functions for repeatable results.
+	 */
+	for _, counter := range []struct {
+		subfn uint16
+		value *uint16
+	}{
+		{ReturnBusMessageCount, &counters.BusMessages},
+		{ReturnBusCommunicationErrorCount, &counters.BusCommunicationErrors},
+		{ReturnBusExceptionErrorCount, &counters.BusExceptionErrors},
+		{ReturnServerMessageCount, &counters.ServerMessages},
+		{ReturnServerNoResponseCount, &counters.ServerNoResponses},
+		{ReturnServerNAKCount, &counters.ServerNAKs},
+		{ReturnServerBusyCount, &counters.ServerBusy},
+		{ReturnBusCharacterOverrunCount, &counters.BusCharacterOverruns},
+	} {
+		value, err := c.DiagnosticCounterContext(ctx, counter.subfn)
+		if err != nil {
+			return nil, err
+		}
+		*counter.value = value
+	}
+	return counters, nil
+}
+
+// commBusy interprets the status word of the comm event counter and log
+func commBusy(status uint16) (bool, error) {
+	switch status {
+	case 0x0000:
+		return false, nil
+	case CommBusy:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: Illegal comm status: %#x", ErrResponse, status)
+	}
+}
+
+// GetCommEventCounter reads the count of messages successfully completed by the device
+func (c *Client) GetCommEventCounter() (*CommEventCounter, error) {
+	return c.GetCommEventCounterContext(context.Background())
+}
+
+// GetCommEventCounterContext is GetCommEventCounter, abandoned as soon as the context is done
+func (c *Client) GetCommEventCounterContext(ctx context.Context) (*CommEventCounter, error) {
+	response, err := c.execute(ctx, &Request{FnCode: GCEC})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) != 4 {
+		return nil, fmt.Errorf("%w: Illegal comm event counter length: %v", ErrResponse, len(response.Data))
+	}
+	busy, err := commBusy(binary.BigEndian.Uint16(response.Data))
+	if err != nil {
+		return nil, err
+	}
+	return &CommEventCounter{
+		Busy:       busy,
+		EventCount: binary.BigEndian.Uint16(response.Data[2:]),
+	}, nil
+}
+
+// GetCommEventLog reads the comm event log of the device, with its event and message counts
+func (c *Client) GetCommEventLog() (*CommEventLog, error) {
+	return c.GetCommEventLogContext(context.Background())
+}
+
+// GetCommEventLogContext is GetCommEventLog, abandoned as soon as the context is done
+func (c *Client) GetCommEventLogContext(ctx context.Context) (*CommEventLog, error) {
+	response, err := c.execute(ctx, &Request{FnCode: GCEL})
+	if err != nil {
+		return nil, err
+	}
+	// Data[0] holds the byte count followed by the status, event count, message count and events
+	if len(response.Data) < 7 || len(response.Data) != 1+int(response.Data[0]) {
+		return nil, fmt.Errorf("%w: Illegal comm event log length: %v", ErrResponse, response.Data)
+	}
+	busy, err := commBusy(binary.BigEndian.Uint16(response.Data[1:]))
+	if err != nil {
+		return nil, err
+	}
+	return &CommEventLog{
+		Busy:         busy,
+		EventCount:   binary.BigEndian.Uint16(response.Data[3:]),
+		MessageCount: binary.BigEndian.Uint16(response.Data[5:]),
+		Events:       append([]byte{}, response.Data[7:]...),
+	}, nil
+}
+
+// Connect opens the client transport for a sequence of requests
+func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext opens the client transport, giving up once the context is done
+func (c *Client) ConnectContext(ctx context.Context) error {
+	if c == nil {
+		return fmt.Errorf("Illegal client")
+	}
+	return c.Transport.ConnectContext(ctx)
+}
+
+/*
+ * Multiplex connects a ModbusTCP client, unless it is connected already, and
+ * switches it to pipelined mode, in which up to window transactions are
+ * outstanding on the connection at once. The client is then safe for
+ * concurrent use and remains multiplexed until it is closed.
+ */
+func (c *Client) Multiplex(window int) error {
+	if c == nil {
+		return fmt.Errorf("Illegal client")
+	}
+	tcp, ok := c.Transport.(*TCP)
+	if !ok {
+		return fmt.Errorf("Multiplexing requires a TCP transport: %T", c.Transport)
+	}
+	protocol, ok := c.Protocol.(*ModbusTCP)
+	if !ok {
+		return fmt.Errorf("Multiplexing requires the ModbusTCP protocol: %T", c.Protocol)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.mux != nil {
+		return fmt.Errorf("Client already multiplexed")
+	}
+	if tcp.Conn == nil {
+		if err := tcp.Connect(); err != nil {
+			return fmt.Errorf("Client connection failed: %s", err)
+		}
+	}
+	mux, err := NewMux(tcp, protocol, window)
+	if err != nil {
+		tcp.Close()
+		return err
+	}
+	c.mux = mux
+	return nil
 }
 
 func (c *Client) Close() {
 	// Close and dispose of connection
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.mux != nil {
+		c.mux.Close()
+		c.mux = nil
+		return
+	}
 	c.Transport.Close()
 }
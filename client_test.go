@@ -0,0 +1,119 @@
+package modbusd
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exchange is a request frame expected by a fakeTransport and the captured response it replays
+type exchange struct {
+	request  string // Hex digits, spaces ignored
+	response string // Hex digits, with | separating the fragments returned by successive reads
+}
+
+// fakeTimeout is the error of a read from a fakeTransport with nothing left to return
+type fakeTimeout struct{}
+
+func (fakeTimeout) Error() string { return "Fake read timed out" }
+func (fakeTimeout) Timeout() bool { return true }
+
+/*
+ * fakeTransport replays captured responses without a connection, checking
+ * that each request sent is the one expected next.
+ */
+type fakeTransport struct {
+	TransportBase
+	t         *testing.T
+	exchanges []exchange
+	fragments [][]byte
+}
+
+// fakeClient creates a client of the protocol on a fakeTransport that expects all the exchanges
+func fakeClient(t *testing.T, protocol Protocol, exchanges ...exchange) *Client {
+	transport := &fakeTransport{t: t, exchanges: exchanges}
+	t.Cleanup(func() {
+		for _, unsent := range transport.exchanges {
+			t.Errorf("Request never sent: %s", unsent.request)
+		}
+	})
+	return &Client{Protocol: protocol, Transport: transport}
+}
+
+// unhex decodes hex digits, ignoring spaces
+func unhex(t *testing.T, digits string) []byte {
+	decoded, err := hex.DecodeString(strings.ReplaceAll(digits, " ", ""))
+	if err != nil {
+		t.Fatalf("Illegal hex digits %q: %s", digits, err)
+	}
+	return decoded
+}
+
+func (f *fakeTransport) Connect() error {
+	return nil
+}
+
+func (f *fakeTransport) ConnectContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (f *fakeTransport) Send(adu *ADU) error {
+	return f.SendContext(context.Background(), adu)
+}
+
+func (f *fakeTransport) SendContext(ctx context.Context, adu *ADU) error {
+	request, err := adu.Bytes()
+	if err != nil {
+		return err
+	}
+	if len(f.exchanges) == 0 {
+		f.t.Errorf("Unexpected request %x", request)
+		return fmt.Errorf("Unexpected request")
+	}
+	next := f.exchanges[0]
+	f.exchanges = f.exchanges[1:]
+	if expected := unhex(f.t, next.request); !bytes.Equal(request, expected) {
+		f.t.Errorf("Request %x!=%x", request, expected)
+	}
+	f.fragments = nil
+	for _, fragment := range strings.Split(next.response, "|") {
+		if decoded := unhex(f.t, fragment); len(decoded) > 0 {
+			f.fragments = append(f.fragments, decoded)
+		}
+	}
+	return nil
+}
+
+func (f *fakeTransport) Listen(done chan bool) error {
+	return f.ListenContext(context.Background())
+}
+
+func (f *fakeTransport) ListenContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(f.fragments) == 0 {
+		return fakeTimeout{}
+	}
+	f.Lock()
+	f.Response.Write(f.fragments[0])
+	f.Unlock()
+	f.fragments = f.fragments[1:]
+	return nil
+}
+
+func (f *fakeTransport) Receive(wait time.Duration) error {
+	return f.ListenContext(context.Background())
+}
+
+func (f *fakeTransport) ReceiveContext(ctx context.Context, wait time.Duration) error {
+	return f.ListenContext(ctx)
+}
+
+func (f *fakeTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,359 @@
+package modbusd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type Order int
+
+/*
+ * Order is the arrangement of a value spread across registers, named after
+ * the position of the bytes A (most significant) to D of a 32-bit value.
+ * The same swaps apply to the words of 64-bit values and to the bytes of
+ * 16-bit values, for which the word order is irrelevant.
+ */
+const (
+	ABCD Order = 0 // Big endian bytes and words, as defined by the specification
+	CDAB Order = 1 // Big endian bytes, least significant word first
+	BADC Order = 2 // Bytes swapped within each register, most significant word first
+	DCBA Order = 3 // Little endian bytes and words
+)
+
+var orders = map[Order]string{
+	ABCD: "ABCD",
+	CDAB: "CDAB",
+	BADC: "BADC",
+	DCBA: "DCBA",
+}
+
+// ParseOrder parses the name of a byte and word order, e.g. CDAB
+func ParseOrder(name string) (Order, error) {
+	for order, n := range orders {
+		if strings.EqualFold(n, name) {
+			return order, nil
+		}
+	}
+	return ABCD, fmt.Errorf("Unknown byte order: %s", name)
+}
+
+func (o Order) String() string {
+	if name, found := orders[o]; found {
+		return name
+	}
+	return fmt.Sprintf("Order(%d)", int(o))
+}
+
+func (o Order) wordSwap() bool {
+	return o == CDAB || o == DCBA
+}
+
+func (o Order) byteSwap() bool {
+	return o == BADC || o == DCBA
+}
+
+// bytes arranges count registers as the big endian bytes of a value
+func (o Order) bytes(registers []uint16, count int) ([]byte, error) {
+	if _, found := orders[o]; !found {
+		return nil, fmt.Errorf("Unknown byte order: %v", o)
+	}
+	if len(registers) < count {
+		return nil, fmt.Errorf("Illegal number of registers: %v<%v", len(registers), count)
+	}
+	data := make([]byte, 2*count)
+	for idx, register := range registers[:count] {
+		pos := idx
+		if o.wordSwap() {
+			pos = count - 1 - idx
+		}
+		if o.byteSwap() {
+			register = register<<8 | register>>8
+		}
+		binary.BigEndian.PutUint16(data[2*pos:], register)
+	}
+	return data, nil
+}
+
+// put spreads the big endian bytes of a value across registers
+func (o Order) put(registers []uint16, data []byte) error {
+	if _, found := orders[o]; !found {
+		return fmt.Errorf("Unknown byte order: %v", o)
+	}
+	count := len(data) / 2
+	if len(registers) < count {
+		return fmt.Errorf("Illegal number of registers: %v<%v", len(registers), count)
+	}
+	for idx := range registers[:count] {
+		pos := idx
+		if o.wordSwap() {
+			pos = count - 1 - idx
+		}
+		register := binary.BigEndian.Uint16(data[2*pos:])
+		if o.byteSwap() {
+			register = register<<8 | register>>8
+		}
+		registers[idx] = register
+	}
+	return nil
+}
+
+// Uint16 decodes an unsigned 16-bit value from the first register
+func (o Order) Uint16(registers []uint16) (uint16, error) {
+	data, err := o.bytes(registers, 1)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(data), nil
+}
+
+// Int16 decodes a signed 16-bit value from the first register
+func (o Order) Int16(registers []uint16) (int16, error) {
+	value, err := o.Uint16(registers)
+	return int16(value), err
+}
+
+// Uint32 decodes an unsigned 32-bit value from the first two registers
+func (o Order) Uint32(registers []uint16) (uint32, error) {
+	data, err := o.bytes(registers, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// Int32 decodes a signed 32-bit value from the first two registers
+func (o Order) Int32(registers []uint16) (int32, error) {
+	value, err := o.Uint32(registers)
+	return int32(value), err
+}
+
+// Uint64 decodes an unsigned 64-bit value from the first four registers
+func (o Order) Uint64(registers []uint16) (uint64, error) {
+	data, err := o.bytes(registers, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// Int64 decodes a signed 64-bit value from the first four registers
+func (o Order) Int64(registers []uint16) (int64, error) {
+	value, err := o.Uint64(registers)
+	return int64(value), err
+}
+
+// Float32 decodes an IEEE-754 single precision value from the first two registers
+func (o Order) Float32(registers []uint16) (float32, error) {
+	value, err := o.Uint32(registers)
+	return math.Float32frombits(value), err
+}
+
+// Float64 decodes an IEEE-754 double precision value from the first four registers
+func (o Order) Float64(registers []uint16) (float64, error) {
+	value, err := o.Uint64(registers)
+	return math.Float64frombits(value), err
+}
+
+// PutUint16 encodes an unsigned 16-bit value into the first register
+func (o Order) PutUint16(registers []uint16, value uint16) error {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	return o.put(registers, data)
+}
+
+// PutInt16 encodes a signed 16-bit value into the first register
+func (o Order) PutInt16(registers []uint16, value int16) error {
+	return o.PutUint16(registers, uint16(value))
+}
+
+// PutUint32 encodes an unsigned 32-bit value into the first two registers
+func (o Order) PutUint32(registers []uint16, value uint32) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	return o.put(registers, data)
+}
+
+// PutInt32 encodes a signed 32-bit value into the first two registers
+func (o Order) PutInt32(registers []uint16, value int32) error {
+	return o.PutUint32(registers, uint32(value))
+}
+
+// PutUint64 encodes an unsigned 64-bit value into the first four registers
+func (o Order) PutUint64(registers []uint16, value uint64) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return o.put(registers, data)
+}
+
+// PutInt64 encodes a signed 64-bit value into the first four registers
+func (o Order) PutInt64(registers []uint16, value int64) error {
+	return o.PutUint64(registers, uint64(value))
+}
+
+// PutFloat32 encodes an IEEE-754 single precision value into the first two registers
+func (o Order) PutFloat32(registers []uint16, value float32) error {
+	return o.PutUint32(registers, math.Float32bits(value))
+}
+
+// PutFloat64 encodes an IEEE-754 double precision value into the first four registers
+func (o Order) PutFloat64(registers []uint16, value float64) error {
+	return o.PutUint64(registers, math.Float64bits(value))
+}
+
+// Padding characters trimmed from decoded text
+const (
+	TrimNone    = ""
+	TrimNUL     = "\x00"
+	TrimSpace   = " "
+	TrimPadding = "\x00 "
+)
+
+/*
+ * Text decodes a fixed length string packed two characters to a register,
+ * trimming the characters in the cutset from both ends. The characters
+ * follow each other from the first register, so only the byte swap of the
+ * order applies.
+ */
+func (o Order) Text(registers []uint16, cutset string) (string, error) {
+	data, err := o.characters(registers)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(data), cutset), nil
+}
+
+// PutText encodes a string into the registers two bytes to a register, padded with NUL characters
+func (o Order) PutText(registers []uint16, text string) error {
+	if len(text) > 2*len(registers) {
+		return fmt.Errorf("Text exceeds %v registers: %v bytes", len(registers), len(text))
+	}
+	data := make([]byte, 2*len(registers))
+	copy(data, text)
+	for idx := range registers {
+		register := binary.BigEndian.Uint16(data[2*idx:])
+		if o.byteSwap() {
+			register = register<<8 | register>>8
+		}
+		registers[idx] = register
+	}
+	return nil
+}
+
+// characters arranges the registers as the bytes of text in sequence
+func (o Order) characters(registers []uint16) ([]byte, error) {
+	if _, found := orders[o]; !found {
+		return nil, fmt.Errorf("Unknown byte order: %v", o)
+	}
+	data := make([]byte, 2*len(registers))
+	for idx, register := range registers {
+		if o.byteSwap() {
+			register = register<<8 | register>>8
+		}
+		binary.BigEndian.PutUint16(data[2*idx:], register)
+	}
+	return data, nil
+}
+
+// value decodes the registers as a single unsigned value of up to 64 bits
+func (o Order) value(registers []uint16) (uint64, error) {
+	if len(registers) < 1 || len(registers) > 4 {
+		return 0, fmt.Errorf("Illegal number of registers: %v", len(registers))
+	}
+	data, err := o.bytes(registers, len(registers))
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// putValue encodes an unsigned value into all of the registers
+func (o Order) putValue(registers []uint16, value uint64) error {
+	if len(registers) < 1 || len(registers) > 4 {
+		return fmt.Errorf("Illegal number of registers: %v", len(registers))
+	}
+	data := make([]byte, 2*len(registers))
+	for idx := len(data) - 1; idx >= 0; idx-- {
+		data[idx] = byte(value)
+		value >>= 8
+	}
+	return o.put(registers, data)
+}
+
+// BCD decodes packed binary coded decimal, four digits to a register, from up to four registers
+func (o Order) BCD(registers []uint16) (uint64, error) {
+	packed, err := o.value(registers)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for shift := 16*len(registers) - 4; shift >= 0; shift -= 4 {
+		digit := (packed >> uint(shift)) & 0x0F
+		if digit > 9 {
+			return 0, fmt.Errorf("Illegal BCD digit: %#x", packed)
+		}
+		value = value*10 + digit
+	}
+	return value, nil
+}
+
+// PutBCD encodes a value as packed binary coded decimal into all of the registers
+func (o Order) PutBCD(registers []uint16, value uint64) error {
+	var packed uint64
+	remainder := value
+	for shift := 0; shift < 16*len(registers) && shift < 64; shift += 4 {
+		packed |= (remainder % 10) << uint(shift)
+		remainder /= 10
+	}
+	if remainder > 0 {
+		return fmt.Errorf("Value exceeds %v BCD digits: %v", 4*len(registers), value)
+	}
+	return o.putValue(registers, packed)
+}
+
+// Bitfield names the bits of a value, numbered from 0 for the least significant bit
+type Bitfield map[string]uint
+
+// Bits extracts the named flags from a value of up to four registers
+func (o Order) Bits(registers []uint16, field Bitfield) (map[string]bool, error) {
+	value, err := o.value(registers)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]bool, len(field))
+	for name, bit := range field {
+		if bit >= uint(16*len(registers)) {
+			return nil, fmt.Errorf("Illegal bit for %v registers: %s=%v", len(registers), name, bit)
+		}
+		flags[name] = value&(1<<bit) != 0
+	}
+	return flags, nil
+}
+
+// PutBits sets or clears the named flags in a value of up to four registers, leaving the other bits unchanged
+func (o Order) PutBits(registers []uint16, field Bitfield, flags map[string]bool) error {
+	value, err := o.value(registers)
+	if err != nil {
+		return err
+	}
+	for name, set := range flags {
+		bit, found := field[name]
+		if !found {
+			return fmt.Errorf("Unknown flag: %s", name)
+		}
+		if bit >= uint(16*len(registers)) {
+			return fmt.Errorf("Illegal bit for %v registers: %s=%v", len(registers), name, bit)
+		}
+		if set {
+			value |= 1 << bit
+		} else {
+			value &^= 1 << bit
+		}
+	}
+	return o.putValue(registers, value)
+}
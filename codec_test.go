@@ -0,0 +1,234 @@
+package modbusd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOrderFloat32(t *testing.T) {
+	// Single precision pi, 0x40490FDB
+	pi := float32(math.Pi)
+	tests := []struct {
+		order     Order
+		registers []uint16
+	}{
+		{ABCD, []uint16{0x4049, 0x0FDB}},
+		{CDAB, []uint16{0x0FDB, 0x4049}},
+		{BADC, []uint16{0x4940, 0xDB0F}},
+		{DCBA, []uint16{0xDB0F, 0x4940}},
+	}
+	for _, test := range tests {
+		value, err := test.order.Float32(test.registers)
+		if err != nil || value != pi {
+			t.Errorf("%v Float32(%#04x) = %v, %v; want %v", test.order, test.registers, value, err, pi)
+		}
+		registers := make([]uint16, 2)
+		if err = test.order.PutFloat32(registers, pi); err != nil {
+			t.Fatal(err)
+		}
+		if registers[0] != test.registers[0] || registers[1] != test.registers[1] {
+			t.Errorf("%v PutFloat32 = %#04x; want %#04x", test.order, registers, test.registers)
+		}
+	}
+}
+
+func TestOrderSigned(t *testing.T) {
+	for _, order := range []Order{ABCD, CDAB, BADC, DCBA} {
+		registers := make([]uint16, 4)
+		for _, value := range []int16{0, 1, -1, math.MinInt16, math.MaxInt16} {
+			if err := order.PutInt16(registers, value); err != nil {
+				t.Fatal(err)
+			}
+			if decoded, err := order.Int16(registers); err != nil || decoded != value {
+				t.Errorf("%v Int16 = %v, %v; want %v", order, decoded, err, value)
+			}
+		}
+		for _, value := range []int32{0, 1, -1, -70000, math.MinInt32, math.MaxInt32} {
+			if err := order.PutInt32(registers, value); err != nil {
+				t.Fatal(err)
+			}
+			if decoded, err := order.Int32(registers); err != nil || decoded != value {
+				t.Errorf("%v Int32 = %v, %v; want %v", order, decoded, err, value)
+			}
+		}
+		for _, value := range []int64{0, 1, -1, -5000000000, math.MinInt64, math.MaxInt64} {
+			if err := order.PutInt64(registers, value); err != nil {
+				t.Fatal(err)
+			}
+			if decoded, err := order.Int64(registers); err != nil || decoded != value {
+				t.Errorf("%v Int64 = %v, %v; want %v", order, decoded, err, value)
+			}
+		}
+	}
+	// Sign extension of the registers as defined by the specification
+	if value, _ := ABCD.Int16([]uint16{0xFFFE}); value != -2 {
+		t.Errorf("Int16(0xFFFE) = %v; want -2", value)
+	}
+	if value, _ := ABCD.Int32([]uint16{0xFFFF, 0xFFFE}); value != -2 {
+		t.Errorf("Int32(0xFFFF, 0xFFFE) = %v; want -2", value)
+	}
+	if value, _ := CDAB.Int64([]uint16{0xFFFE, 0xFFFF, 0xFFFF, 0xFFFF}); value != -2 {
+		t.Errorf("CDAB Int64 = %v; want -2", value)
+	}
+}
+
+func TestOrderRoundTrip(t *testing.T) {
+	for _, order := range []Order{ABCD, CDAB, BADC, DCBA} {
+		registers := make([]uint16, 4)
+		if err := order.PutUint16(registers, 0xA1B2); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := order.Uint16(registers); err != nil || value != 0xA1B2 {
+			t.Errorf("%v Uint16 = %#x, %v", order, value, err)
+		}
+		if err := order.PutUint32(registers, 0xA1B2C3D4); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := order.Uint32(registers); err != nil || value != 0xA1B2C3D4 {
+			t.Errorf("%v Uint32 = %#x, %v", order, value, err)
+		}
+		if err := order.PutUint64(registers, 0xA1B2C3D4E5F60718); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := order.Uint64(registers); err != nil || value != 0xA1B2C3D4E5F60718 {
+			t.Errorf("%v Uint64 = %#x, %v", order, value, err)
+		}
+		if err := order.PutFloat64(registers, -math.E); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := order.Float64(registers); err != nil || value != -math.E {
+			t.Errorf("%v Float64 = %v, %v", order, value, err)
+		}
+	}
+	// The word order of 64-bit values is reversed as a whole
+	registers := make([]uint16, 4)
+	CDAB.PutUint64(registers, 0x0001000200030004)
+	if registers[0] != 4 || registers[3] != 1 {
+		t.Errorf("CDAB PutUint64 = %#04x", registers)
+	}
+}
+
+func TestOrderErrors(t *testing.T) {
+	if _, err := ABCD.Uint16(nil); err == nil {
+		t.Error("Uint16 of no registers")
+	}
+	if _, err := CDAB.Float32([]uint16{1}); err == nil {
+		t.Error("Float32 of a single register")
+	}
+	if _, err := DCBA.Int64([]uint16{1, 2, 3}); err == nil {
+		t.Error("Int64 of three registers")
+	}
+	if err := BADC.PutUint32(make([]uint16, 1), 1); err == nil {
+		t.Error("PutUint32 into a single register")
+	}
+	if _, err := Order(9).Uint16([]uint16{1}); err == nil {
+		t.Error("Unknown order")
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	for _, order := range []Order{ABCD, CDAB, BADC, DCBA} {
+		if parsed, err := ParseOrder(order.String()); err != nil || parsed != order {
+			t.Errorf("ParseOrder(%v) = %v, %v", order, parsed, err)
+		}
+	}
+	if parsed, err := ParseOrder("cdab"); err != nil || parsed != CDAB {
+		t.Errorf("ParseOrder(cdab) = %v, %v", parsed, err)
+	}
+	if _, err := ParseOrder("ACBD"); err == nil {
+		t.Error("ParseOrder(ACBD)")
+	}
+}
+
+func TestText(t *testing.T) {
+	// A serial number packed two characters to a register, with the bytes swapped by the device
+	swapped := []uint16{0x4E53, 0x3231, 0x3433, 0x0035}
+	if text, err := BADC.Text(swapped, TrimNUL); err != nil || text != "SN12345" {
+		t.Errorf("BADC Text = %q, %v", text, err)
+	}
+	if text, err := ABCD.Text(swapped, TrimNUL); err != nil || text != "NS2143\x005" {
+		t.Errorf("ABCD Text = %q, %v", text, err)
+	}
+	registers := make([]uint16, 4)
+	if err := DCBA.PutText(registers, "SN12345"); err != nil {
+		t.Fatal(err)
+	}
+	for idx := range swapped {
+		if registers[idx] != swapped[idx] {
+			t.Fatalf("DCBA PutText = %#04x; want %#04x", registers, swapped)
+		}
+	}
+
+	padded := []uint16{0x4142, 0x2020, 0x0000}
+	tests := []struct {
+		cutset string
+		text   string
+	}{
+		{TrimNone, "AB  \x00\x00"},
+		{TrimNUL, "AB  "},
+		{TrimSpace, "AB  \x00\x00"},
+		{TrimPadding, "AB"},
+	}
+	for _, test := range tests {
+		if text, err := ABCD.Text(padded, test.cutset); err != nil || text != test.text {
+			t.Errorf("Text(%q) = %q, %v; want %q", test.cutset, text, err, test.text)
+		}
+	}
+	if text, _ := ABCD.Text([]uint16{0x2041, 0x4220}, TrimSpace); text != "AB" {
+		t.Errorf("Text trimmed of spaces = %q", text)
+	}
+	if err := ABCD.PutText(make([]uint16, 2), "12345"); err == nil {
+		t.Error("PutText exceeding the registers")
+	}
+}
+
+func TestBCD(t *testing.T) {
+	if value, err := ABCD.BCD([]uint16{0x1234, 0x5678}); err != nil || value != 12345678 {
+		t.Errorf("BCD = %v, %v", value, err)
+	}
+	if value, err := CDAB.BCD([]uint16{0x5678, 0x1234}); err != nil || value != 12345678 {
+		t.Errorf("CDAB BCD = %v, %v", value, err)
+	}
+	if _, err := ABCD.BCD([]uint16{0x12A4}); err == nil {
+		t.Error("BCD with an invalid nibble")
+	}
+	if _, err := ABCD.BCD(make([]uint16, 5)); err == nil {
+		t.Error("BCD of five registers")
+	}
+	registers := make([]uint16, 2)
+	if err := ABCD.PutBCD(registers, 9876543); err != nil || registers[0] != 0x0987 || registers[1] != 0x6543 {
+		t.Errorf("PutBCD = %#04x, %v", registers, err)
+	}
+	if err := ABCD.PutBCD(registers[:1], 9999); err != nil || registers[0] != 0x9999 {
+		t.Errorf("PutBCD(9999) = %#04x, %v", registers[0], err)
+	}
+	if err := ABCD.PutBCD(registers[:1], 10000); err == nil {
+		t.Error("PutBCD overflowing a register")
+	}
+}
+
+func TestBits(t *testing.T) {
+	field := Bitfield{"run": 0, "fault": 5, "remote": 17}
+	flags, err := CDAB.Bits([]uint16{0x0021, 0x0002}, field)
+	if err != nil || !flags["run"] || !flags["fault"] || !flags["remote"] {
+		t.Errorf("Bits = %v, %v", flags, err)
+	}
+	if _, err = ABCD.Bits([]uint16{0}, field); err == nil {
+		t.Error("Bits beyond the register")
+	}
+
+	// Only the named flags change
+	registers := []uint16{0xA5A5}
+	if err = ABCD.PutBits(registers, Bitfield{"a": 0, "b": 1, "c": 15}, map[string]bool{"a": false, "b": true}); err != nil {
+		t.Fatal(err)
+	}
+	if registers[0] != 0xA5A6 {
+		t.Errorf("PutBits = %#04x; want 0xa5a6", registers[0])
+	}
+	if err = ABCD.PutBits(registers, Bitfield{"a": 0}, map[string]bool{"b": true}); err == nil {
+		t.Error("PutBits of an unknown flag")
+	}
+	if registers[0] != 0xA5A6 {
+		t.Errorf("Failed PutBits changed the register: %#04x", registers[0])
+	}
+}
@@ -0,0 +1,95 @@
+package modbusd
+
+import (
+	"fmt"
+)
+
+// MEI type of the Read Device Identification interface, encapsulated by MEIT
+const MEIDeviceId byte = 0x0E
+
+// Length of the read device identification response ahead of its objects
+const LDEVID Length = 6
+
+type DeviceIdCode byte
+
+/*
+ * DeviceIdCode is the access requested of a read device identification.
+ * The stream access codes read all objects of a category from the object
+ * id onwards, whereas individual access reads the single object.
+ */
+const (
+	BasicDeviceId      DeviceIdCode = 0x01 // Stream access to the basic objects
+	RegularDeviceId    DeviceIdCode = 0x02 // Stream access to the basic and regular objects
+	ExtendedDeviceId   DeviceIdCode = 0x03 // Stream access to all objects, including the private objects
+	IndividualDeviceId DeviceIdCode = 0x04 // Access to a single object
+)
+
+type ObjectId byte
+
+// Objects defined by the specification, with private objects from 0x80
+const (
+	VendorName          ObjectId = 0x00
+	ProductCode         ObjectId = 0x01
+	MajorMinorRevision  ObjectId = 0x02
+	VendorUrl           ObjectId = 0x03
+	ProductName         ObjectId = 0x04
+	ModelName           ObjectId = 0x05
+	UserApplicationName ObjectId = 0x06
+)
+
+// Basic form of a read device identification response
+type DeviceIdentification struct {
+	Code         DeviceIdCode
+	Conformity   byte
+	MoreFollows  bool
+	NextObjectId ObjectId
+	Objects      map[ObjectId]string
+}
+
+/*
+ * deviceIdLength returns the length of the read device identification
+ * response at the start of data, from the MEI type to the end of the last
+ * object. False is returned while the header of an object is still missing.
+ */
+func deviceIdLength(data []byte) (int, bool) {
+	if len(data) < int(LDEVID) {
+		return 0, false
+	}
+	length := int(LDEVID)
+	for idx := 0; idx < int(data[LDEVID-1]); idx++ {
+		// Object id and length precede the value of each object
+		if len(data) < length+2 {
+			return 0, false
+		}
+		length += 2 + int(data[length+1])
+	}
+	return length, true
+}
+
+// NewDeviceIdentification decodes the data of a read device identification response
+func NewDeviceIdentification(data []byte) (*DeviceIdentification, error) {
+	length, complete := deviceIdLength(data)
+	if !complete || length != len(data) {
+		return nil, fmt.Errorf("%w: Illegal device identification length: %v", ErrResponse, data)
+	}
+	if data[0] != MEIDeviceId {
+		return nil, fmt.Errorf("%w: Unexpected MEI type: %#x", ErrResponse, data[0])
+	}
+	id := &DeviceIdentification{
+		Code:         DeviceIdCode(data[1]),
+		Conformity:   data[2],
+		NextObjectId: ObjectId(data[4]),
+		Objects:      make(map[ObjectId]string, data[5]),
+	}
+	switch data[3] {
+	case 0x00:
+	case 0xFF:
+		id.MoreFollows = true
+	default:
+		return nil, fmt.Errorf("%w: Illegal more follows: %#x", ErrResponse, data[3])
+	}
+	for object := data[LDEVID:]; len(object) > 0; object = object[2+int(object[1]):] {
+		id.Objects[ObjectId(object[0])] = string(object[2 : 2+int(object[1])])
+	}
+	return id, nil
+}
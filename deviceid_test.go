@@ -0,0 +1,93 @@
+package modbusd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadDeviceIdentification(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      DeviceIdCode
+		object    ObjectId
+		exchanges []exchange
+		objects   map[ObjectId]string
+	}{
+		{
+			"basic objects",
+			BasicDeviceId, VendorName,
+			[]exchange{{
+				"0000 0000 0005 01 2B 0E 01 00",
+				"0000 0000 001D 01 2B 0E 01 01 00 00 03 00 07 436F6D70616E79 01 03 505244 02 05 56322E3131",
+			}},
+			map[ObjectId]string{VendorName: "Company", ProductCode: "PRD", MajorMinorRevision: "V2.11"},
+		},
+		{
+			// More objects follow the first response, from the next object id
+			"regular objects",
+			RegularDeviceId, VendorName,
+			[]exchange{{
+				"0000 0000 0005 01 2B 0E 02 00",
+				"0000 0000 0016 01 2B 0E 02 02 FF 02 02 00 07 436F6D70616E79 01 03 505244",
+			}, {
+				"0001 0000 0005 01 2B 0E 02 02",
+				"0001 0000 0015 01 2B 0E 02 02 00 00 02 02 05 56322E3131 04 04 50756D70",
+			}},
+			map[ObjectId]string{VendorName: "Company", ProductCode: "PRD", MajorMinorRevision: "V2.11", ProductName: "Pump"},
+		},
+		{
+			"a private object",
+			IndividualDeviceId, 0x80,
+			[]exchange{{
+				"0000 0000 0005 01 2B 0E 04 80",
+				"0000 0000 000D 01 2B 0E 04 83 00 00 01 80 03 582D31",
+			}},
+			map[ObjectId]string{0x80: "X-1"},
+		},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		c := fakeClient(t, protocol, test.exchanges...)
+		objects, err := c.ReadDeviceIdentification(test.code, test.object)
+		if err != nil || !reflect.DeepEqual(objects, test.objects) {
+			t.Errorf("ReadDeviceIdentification of %s = %q, %v; want %q", test.name, objects, err, test.objects)
+		}
+	}
+}
+
+func TestReadDeviceIdentificationErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     DeviceIdCode
+		response string
+		err      error
+	}{
+		{"another access code", BasicDeviceId, "0000 0000 0011 01 2B 0E 02 01 00 00 01 00 07 436F6D70616E79", ErrResponse},
+		{"an illegal more follows", BasicDeviceId, "0000 0000 0011 01 2B 0E 01 01 01 00 01 00 07 436F6D70616E79", ErrResponse},
+		{"a continuation to an object read", RegularDeviceId, "0000 0000 0011 01 2B 0E 02 02 FF 00 01 00 07 436F6D70616E79", ErrResponse},
+		{"an exception", BasicDeviceId, "0000 0000 0003 01 AB 01", IllegalFunction},
+		{"an object beyond the frame", BasicDeviceId, "0000 0000 000D 01 2B 0E 01 01 00 00 01 00 07 436F6D", ErrFrame},
+		{"a number of objects beyond the frame", BasicDeviceId, "0000 0000 000D 01 2B 0E 01 01 00 00 02 01 03 505244", ErrFrame},
+		{"objects followed by more bytes", BasicDeviceId, "0000 0000 000E 01 2B 0E 01 01 00 00 01 01 03 505244 00", ErrFrame},
+		{"a truncated header", BasicDeviceId, "0000 0000 0006 01 2B 0E 01 01 00", ErrFrame},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		expected := exchange{"0000 0000 0005 01 2B 0E 01 00", test.response}
+		if test.code == RegularDeviceId {
+			expected.request = "0000 0000 0005 01 2B 0E 02 00"
+		}
+		c := fakeClient(t, protocol, expected)
+		if objects, err := c.ReadDeviceIdentification(test.code, VendorName); !errors.Is(err, test.err) {
+			t.Errorf("ReadDeviceIdentification of %s = %q, %v; want %v", test.name, objects, err, test.err)
+		}
+	}
+
+	// Access codes beyond the specification are refused without a transaction
+	protocol, _ := NewModbusTCP(1)
+	c := fakeClient(t, protocol)
+	if _, err := c.ReadDeviceIdentification(IndividualDeviceId+1, VendorName); err == nil {
+		t.Error("ReadDeviceIdentification of an illegal access code")
+	}
+}
@@ -0,0 +1,55 @@
+package modbusd
+
+// Sub-function codes of the DIAG function code
+const (
+	ReturnQueryData                  uint16 = 0x00
+	RestartCommunicationsOption      uint16 = 0x01
+	ReturnDiagnosticRegister         uint16 = 0x02
+	ChangeASCIIInputDelimiter        uint16 = 0x03
+	ForceListenOnlyMode              uint16 = 0x04 // Not answered by the device
+	ClearCountersAndDiagnostic       uint16 = 0x0A
+	ReturnBusMessageCount            uint16 = 0x0B
+	ReturnBusCommunicationErrorCount uint16 = 0x0C
+	ReturnBusExceptionErrorCount     uint16 = 0x0D
+	ReturnServerMessageCount         uint16 = 0x0E
+	ReturnServerNoResponseCount      uint16 = 0x0F
+	ReturnServerNAKCount             uint16 = 0x10
+	ReturnServerBusyCount            uint16 = 0x11
+	ReturnBusCharacterOverrunCount   uint16 = 0x12
+	ClearOverrunCounterAndFlag       uint16 = 0x14
+)
+
+// Status reported by a device still processing an earlier command
+const CommBusy uint16 = 0xFFFF
+
+// Maximum number of words of diagnostic data that fit a PDU
+const MaxDiagnosticData int = 0x7D
+
+// Counters of the serial line returned by the DIAG sub-functions
+type DiagnosticCounters struct {
+	BusMessages            uint16
+	BusCommunicationErrors uint16 // CRC or LRC errors
+	BusExceptionErrors     uint16
+	ServerMessages         uint16
+	ServerNoResponses      uint16
+	ServerNAKs             uint16
+	ServerBusy             uint16
+	BusCharacterOverruns   uint16
+}
+
+// Response to a get comm event counter request
+type CommEventCounter struct {
+	Busy       bool
+	EventCount uint16
+}
+
+/*
+ * Response to a get comm event log request. The events are a byte each,
+ * the most recent first, as defined for the event log by the specification.
+ */
+type CommEventLog struct {
+	Busy         bool
+	EventCount   uint16
+	MessageCount uint16
+	Events       []byte
+}
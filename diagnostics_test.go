@@ -0,0 +1,116 @@
+package modbusd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	rtu, _ := NewRTU(1)
+	c := fakeClient(t, rtu,
+		// The echo of query data is delimited by the silence after it
+		exchange{"01 08 0000 A537 0102 1B54", "01 08 0000 | A537 0102 1B54"},
+		exchange{"01 08 0001 FF00 F03B", "01 08 0001 FF00 F03B"},
+		exchange{"01 08 0002 0000 41CB", "01 08 0002 8001 E1CB"},
+		exchange{"01 08 0003 0D00 149B", "01 08 0003 0D00 149B"},
+		exchange{"01 08 000A 0000 C009", "01 08 000A 0000 C009"},
+		exchange{"01 08 0014 0000 A00F", "01 08 0014 0000 A00F"},
+		exchange{"01 07 41E2", "01 07 6D E3DD"},
+		exchange{"01 0B 41E7", "01 0B FFFF 0108 A479"},
+		exchange{"01 0C 0025", "01 0C 0A 0000 0108 0121 2000 0000 CEB8"},
+	)
+
+	if err := c.ReturnQueryData([]uint16{0xA537, 0x0102}); err != nil {
+		t.Errorf("ReturnQueryData: %s", err)
+	}
+	if err := c.RestartCommunications(true); err != nil {
+		t.Errorf("RestartCommunications: %s", err)
+	}
+	if register, err := c.ReadDiagnosticRegister(); err != nil || register != 0x8001 {
+		t.Errorf("ReadDiagnosticRegister = %#04x, %v", register, err)
+	}
+	if err := c.ChangeASCIIDelimiter('\r'); err != nil {
+		t.Errorf("ChangeASCIIDelimiter: %s", err)
+	}
+	if err := c.ClearCounters(); err != nil {
+		t.Errorf("ClearCounters: %s", err)
+	}
+	if err := c.ClearOverrunCounter(); err != nil {
+		t.Errorf("ClearOverrunCounter: %s", err)
+	}
+	if status, err := c.ReadExceptionStatus(); err != nil || status != 0x6D {
+		t.Errorf("ReadExceptionStatus = %#02x, %v", status, err)
+	}
+	if counter, err := c.GetCommEventCounter(); err != nil || !counter.Busy || counter.EventCount != 0x0108 {
+		t.Errorf("GetCommEventCounter = %+v, %v", counter, err)
+	}
+	log, err := c.GetCommEventLog()
+	if err != nil || log.Busy || log.EventCount != 0x0108 || log.MessageCount != 0x0121 ||
+		len(log.Events) != 4 || log.Events[0] != 0x20 {
+		t.Errorf("GetCommEventLog = %+v, %v", log, err)
+	}
+}
+
+func TestDiagnosticCounters(t *testing.T) {
+	rtu, _ := NewRTU(1)
+	// The counters are read in the order of their sub-functions
+	c := fakeClient(t, rtu,
+		exchange{"01 08 000B 0000 91C9", "01 08 000B 0101 5199"},
+		exchange{"01 08 000C 0000 2008", "01 08 000C 0102 A059"},
+		exchange{"01 08 000D 0000 71C8", "01 08 000D 0103 3059"},
+		exchange{"01 08 000E 0000 81C8", "01 08 000E 0104 819B"},
+		exchange{"01 08 000F 0000 D008", "01 08 000F 0105 119B"},
+		exchange{"01 08 0010 0000 E1CE", "01 08 0010 0106 605C"},
+		exchange{"01 08 0011 0000 B00E", "01 08 0011 0107 F05C"},
+		exchange{"01 08 0012 0000 400E", "01 08 0012 0108 4058"},
+		exchange{"01 08 000E 0000 81C8", "01 08 000E 0104 819B"},
+	)
+	counters, err := c.DiagnosticCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DiagnosticCounters{0x0101, 0x0102, 0x0103, 0x0104, 0x0105, 0x0106, 0x0107, 0x0108}
+	if *counters != expected {
+		t.Errorf("DiagnosticCounters = %+v; want %+v", *counters, expected)
+	}
+	if count, err := c.DiagnosticCounter(ReturnServerMessageCount); err != nil || count != 0x0104 {
+		t.Errorf("DiagnosticCounter(ReturnServerMessageCount) = %#04x, %v", count, err)
+	}
+	if _, err = c.DiagnosticCounter(ClearCountersAndDiagnostic); err == nil {
+		t.Error("DiagnosticCounter of a sub-function without a counter")
+	}
+}
+
+func TestDiagnosticsErrors(t *testing.T) {
+	rtu, _ := NewRTU(1)
+	c := fakeClient(t, rtu,
+		exchange{"01 08 0000 A537 0102 1B54", "01 08 0000 A537 0103 DA94"},
+		exchange{"01 08 0001 FF00 F03B", "01 08 0001 0000 B1CB"},
+		exchange{"01 08 0001 FF00 F03B", "01 88 01 87C0"},
+		exchange{"01 0B 41E7", "01 0B 1234 0108 E0EB"},
+		exchange{"01 0C 0025", "01 0C 0A 0000 0108 935B"},
+	)
+
+	if err := c.ReturnQueryData([]uint16{0xA537, 0x0102}); !errors.Is(err, ErrResponse) {
+		t.Errorf("ReturnQueryData of altered data = %v; want %v", err, ErrResponse)
+	}
+	if err := c.RestartCommunications(true); !errors.Is(err, ErrResponse) {
+		t.Errorf("RestartCommunications of an altered echo = %v; want %v", err, ErrResponse)
+	}
+	if err := c.RestartCommunications(true); !errors.Is(err, IllegalFunction) {
+		t.Errorf("RestartCommunications of a device without diagnostics = %v; want %v", err, IllegalFunction)
+	}
+	if _, err := c.GetCommEventCounter(); !errors.Is(err, ErrResponse) {
+		t.Errorf("GetCommEventCounter of an illegal status = %v; want %v", err, ErrResponse)
+	}
+	// The byte count exceeds the frame, which ends before the expected length
+	if _, err := c.GetCommEventLog(); !errors.Is(err, ErrFrame) {
+		t.Errorf("GetCommEventLog of a truncated frame = %v; want %v", err, ErrFrame)
+	}
+	if err := c.ReturnQueryData(nil); err == nil {
+		t.Error("ReturnQueryData without data")
+	}
+	if _, err := c.Diagnostic(ForceListenOnlyMode, []uint16{0}); err == nil {
+		t.Error("Diagnostic sub-function without a response")
+	}
+}
@@ -0,0 +1,37 @@
+package modbusd
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+ * Errors reported by clients and protocols. They are wrapped with the details
+ * of each failure, so callers test for them with errors.Is.
+ */
+var (
+	ErrTimeout     = errors.New("Request timed out")
+	ErrCRCMismatch = errors.New("Error checking CRC mismatch")
+	ErrLRCMismatch = errors.New("Error checking LRC mismatch")
+	ErrFrame       = errors.New("Illegal frame")
+	ErrResponse    = errors.New("Invalid response")
+	ErrClosed      = errors.New("Connection closed")
+)
+
+/*
+ * ExceptionError is the exception response of a device to a request. It
+ * unwraps to its exception code, so errors.Is(err, IllegalDataAddress)
+ * holds for an exception response with that code.
+ */
+type ExceptionError struct {
+	FnCode FnCode
+	ExCode ExCode
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("Exception response to function %#02x: %s (%#02x)", byte(e.FnCode), e.ExCode, byte(e.ExCode))
+}
+
+func (e *ExceptionError) Unwrap() error {
+	return e.ExCode
+}
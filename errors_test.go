@@ -0,0 +1,51 @@
+package modbusd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExceptionError(t *testing.T) {
+	tests := []struct {
+		exchange exchange
+		excode   ExCode
+		message  string
+	}{
+		{
+			exchange{"0000 0000 0006 01 03 0064 0001", "0000 0000 0003 01 83 02"},
+			IllegalDataAddress, "Exception response to function 0x03: Illegal data address (0x02)",
+		},
+		{
+			exchange{"0001 0000 0006 01 03 0064 0001", "0001 0000 0003 01 83 0B"},
+			GatewayTargetDeviceFailedToRespond, "Exception response to function 0x03: Gateway target device failed to respond (0x0b)",
+		},
+		// Vendor specific codes are reported as exceptions too
+		{
+			exchange{"0002 0000 0006 01 03 0064 0001", "0002 0000 0003 01 83 80"},
+			ExCode(0x80), "Exception response to function 0x03: Exception code 128 (0x80)",
+		},
+	}
+	protocol, _ := NewModbusTCP(1)
+	c := fakeClient(t, protocol)
+	transport := c.Transport.(*fakeTransport)
+	for _, test := range tests {
+		transport.exchanges = append(transport.exchanges, test.exchange)
+	}
+	for _, test := range tests {
+		_, err := c.ReadHoldingRegisters(100, 1)
+		var exception *ExceptionError
+		if !errors.As(err, &exception) {
+			t.Errorf("ReadHoldingRegisters = %v; want an ExceptionError", err)
+			continue
+		}
+		if exception.FnCode != RDHR || exception.ExCode != test.excode || !errors.Is(err, test.excode) {
+			t.Errorf("ReadHoldingRegisters = %+v; want %v", exception, test.excode)
+		}
+		if errors.Is(err, ServerDeviceFailure) {
+			t.Errorf("%v is %v", err, ServerDeviceFailure)
+		}
+		if err.Error() != test.message {
+			t.Errorf("Error() = %q; want %q", err.Error(), test.message)
+		}
+	}
+}
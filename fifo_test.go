@@ -0,0 +1,34 @@
+package modbusd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadFIFOQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		values   []uint16
+		err      error
+	}{
+		{"a queue", "0000 0000 000A 01 18 0006 0002 01B8 1284", []uint16{0x01B8, 0x1284}, nil},
+		{"an empty queue", "0000 0000 0006 01 18 0002 0000", []uint16{}, nil},
+		{"an exception", "0000 0000 0003 01 98 03", nil, IllegalDataValue},
+		{"a FIFO count beyond 31", "0000 0000 0006 01 18 0002 0020", nil, ErrResponse},
+		{"a FIFO count short of the values", "0000 0000 000A 01 18 0006 0001 01B8 1284", nil, ErrResponse},
+		{"a byte count without room for the FIFO count", "0000 0000 0004 01 18 0000", nil, ErrResponse},
+		{"a byte count beyond the frame", "0000 0000 0008 01 18 0006 0002 01B8", nil, ErrFrame},
+		{"a byte count short of the frame", "0000 0000 000A 01 18 0004 0002 01B8 1284", nil, ErrFrame},
+		{"a truncated byte count", "0000 0000 0003 01 18 00", nil, ErrFrame},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		c := fakeClient(t, protocol, exchange{"0000 0000 0004 01 18 04DE", test.response})
+		values, err := c.ReadFIFOQueue(0x04DE)
+		if !errors.Is(err, test.err) || !reflect.DeepEqual(values, test.values) {
+			t.Errorf("ReadFIFOQueue of %s = %#04x, %v; want %#04x, %v", test.name, values, err, test.values, test.err)
+		}
+	}
+}
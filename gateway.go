@@ -0,0 +1,126 @@
+package modbusd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Delay after a broadcast for the slaves to process it before the bus is used again
+const gatewayTurnaround time.Duration = 100 * time.Millisecond
+
+/*
+ * Gateway bridges Modbus TCP masters onto an RTU bus. Each request is
+ * re-encoded for the slave addressed by the unit id of its MBAP header,
+ * with requests from all connections taking turns on the shared bus.
+ * Requests to unit id 0 are broadcast to all slaves and not answered.
+ */
+type Gateway struct {
+	Serial     *Serial
+	Turnaround time.Duration // Delay after a broadcast
+
+	server *Server
+	rtu    *RTU
+	bus    sync.Mutex
+}
+
+// NewGateway creates an instance of the Gateway class on the serial transport
+func NewGateway(serial *Serial) (*Gateway, error) {
+	if serial == nil {
+		return nil, fmt.Errorf("Illegal serial transport")
+	}
+	rtu, err := NewRTU(0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create protocol: %s", err)
+	}
+	g := &Gateway{
+		Serial:     serial,
+		Turnaround: gatewayTurnaround,
+		rtu:        rtu,
+	}
+	if g.server, err = newServer(g.forward); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ListenAndServe listens on the TCP address and bridges masters until the gateway is closed
+func (g *Gateway) ListenAndServe(address string) error {
+	return g.server.ListenAndServe(address)
+}
+
+// Serve accepts connections on the listener and bridges each on its own goroutine
+func (g *Gateway) Serve(listener net.Listener) error {
+	return g.server.Serve(listener)
+}
+
+// Close stops listening, closes all connections and releases the serial line
+func (g *Gateway) Close() error {
+	err := g.server.Close()
+	g.bus.Lock()
+	defer g.bus.Unlock()
+	if serr := g.Serial.Close(); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+/*
+ * forward relays a request to the addressed slave and returns its response,
+ * or nil for a broadcast. Unit ids beyond the bus and failures to transmit are
+ * answered with GatewayPathUnavailable, while a missing or corrupt reply is
+ * answered with GatewayTargetDeviceFailedToRespond.
+ */
+func (g *Gateway) forward(request *ADU) *PDU {
+	fncode := FnCode(request.FnCode[0])
+	if request.SlaveId > MaxSlaveId {
+		return exception(fncode, GatewayPathUnavailable)
+	}
+
+	g.bus.Lock()
+	defer g.bus.Unlock()
+	if err := g.Serial.Connect(); err != nil {
+		return exception(fncode, GatewayPathUnavailable)
+	}
+	g.rtu.SlaveId = request.SlaveId
+	adu, err := g.rtu.Encode(&PDU{FnCode: request.FnCode, Data: request.Data})
+	if err != nil {
+		return exception(fncode, GatewayPathUnavailable)
+	}
+
+	// Discard stale characters and keep the silence between frames on the bus
+	g.Serial.Lock()
+	g.Serial.Flush()
+	g.Serial.Unlock()
+	time.Sleep(g.rtu.silence(g.Serial))
+	if err = g.Serial.Send(adu); err != nil {
+		return exception(fncode, GatewayPathUnavailable)
+	}
+	if request.SlaveId == Broadcast {
+		// The slaves do not reply, but need time to act on the request
+		time.Sleep(g.Turnaround)
+		return nil
+	}
+	length, err := g.rtu.Assemble(g.Serial)
+	g.Serial.Lock()
+	frame := append([]byte{}, g.Serial.Buffer()[:length]...)
+	g.Serial.Flush()
+	g.Serial.Unlock()
+	if err != nil || length == 0 {
+		return exception(fncode, GatewayTargetDeviceFailedToRespond)
+	}
+
+	// The response is relayed as is, so it is recovered without interpreting the function code
+	response, err := g.rtu.RecoverRequest(frame, SRTU)
+	if err != nil || response.SlaveId != request.SlaveId {
+		return exception(fncode, GatewayTargetDeviceFailedToRespond)
+	}
+	if FnCode(response.FnCode[0])&^FERR != fncode {
+		return exception(fncode, GatewayTargetDeviceFailedToRespond)
+	}
+	return &PDU{
+		FnCode: response.FnCode,
+		Data:   response.Data,
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package modbusd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	master, name := openPty(t)
+	serial, err := NewSerial(name, 19200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial.Timeout = 100 * time.Millisecond
+	gateway, err := NewGateway(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	go gateway.Serve(listener)
+	defer gateway.Close()
+	address := listener.Addr().(*net.TCPAddr)
+	transport, _ := NewTCP(address.IP.String(), uint16(address.Port), time.Second)
+	protocol, _ := NewModbusTCP(5)
+	c := &Client{Protocol: protocol, Transport: transport}
+	defer c.Close()
+
+	// The request is relayed to the addressed slave and its response to the master
+	expected := rtuFrame(t, 5, RDHR, []byte{0, 1, 0, 2})
+	errc := make(chan error, 1)
+	go func() {
+		if request := readPty(master, time.Second); !bytes.Equal(request, expected) {
+			errc <- fmt.Errorf("Request %x!=%x", request, expected)
+			return
+		}
+		_, err := master.Write(rtuFrame(t, 5, RDHR, []byte{4, 0x12, 0x34, 0xAB, 0xCD}))
+		errc <- err
+	}()
+	values, err := c.ReadHoldingRegisters(1, 2)
+	if err != nil || len(values) != 2 || values[0] != 0x1234 || values[1] != 0xABCD {
+		t.Errorf("ReadHoldingRegisters(1, 2) = %#04x, %v", values, err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// Exceptions of the slave are relayed as they are
+	go func() {
+		readPty(master, time.Second)
+		master.Write(rtuFrame(t, 5, RDHR+FERR, []byte{byte(IllegalDataAddress)}))
+	}()
+	if _, err = c.ReadHoldingRegisters(100, 1); !errors.Is(err, IllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters(100, 1) = %v; want %v", err, IllegalDataAddress)
+	}
+
+	// A slave that does not respond
+	if _, err = c.ReadHoldingRegisters(1, 2); !errors.Is(err, GatewayTargetDeviceFailedToRespond) {
+		t.Errorf("ReadHoldingRegisters of a silent slave = %v; want %v", err, GatewayTargetDeviceFailedToRespond)
+	}
+	readPty(master, 50*time.Millisecond)
+
+	// Unit ids beyond the bus are not relayed
+	protocol.SlaveId = MaxSlaveId + 1
+	if _, err = c.ReadHoldingRegisters(1, 2); !errors.Is(err, GatewayPathUnavailable) {
+		t.Errorf("ReadHoldingRegisters of unit %v = %v; want %v", protocol.SlaveId, err, GatewayPathUnavailable)
+	}
+	if request := readPty(master, 50*time.Millisecond); len(request) != 0 {
+		t.Errorf("Request %x relayed for unit %v", request, protocol.SlaveId)
+	}
+
+	// Broadcasts are relayed to all slaves without a response
+	protocol.SlaveId = Broadcast
+	transport.Timeout = 200 * time.Millisecond
+	if err = c.WriteRegister(1, 0xBEEF); !errors.Is(err, ErrTimeout) {
+		t.Errorf("WriteRegister broadcast = %v; want %v", err, ErrTimeout)
+	}
+	expected = rtuFrame(t, Broadcast, WRSR, []byte{0, 1, 0xBE, 0xEF})
+	if request := readPty(master, 50*time.Millisecond); !bytes.Equal(request, expected) {
+		t.Errorf("Broadcast %x!=%x", request, expected)
+	}
+}
@@ -0,0 +1,24 @@
+package modbusd
+
+type LRC struct {
+	sum byte
+}
+
+// Clear to the starting value of the LRC calculation
+func (lrc *LRC) reset() *LRC {
+	lrc.sum = 0
+	return lrc
+}
+
+// Compute a LRC by adding all bytes of the payload, discarding carries
+func (lrc *LRC) calculate(payload []byte) *LRC {
+	for _, b := range payload {
+		lrc.sum += b
+	}
+	return lrc
+}
+
+func (lrc *LRC) value() byte {
+	// Return the two's complement of the sum
+	return -lrc.sum
+}
@@ -42,14 +42,14 @@ const (
 
 	FIFO FnCode = 0x18 // read FIFO queue
 
+	MEIT FnCode = 0x2B // Modbus Encapsulated Interface Transport
+
 	FERR FnCode = 0x80 // Add to FnCode to get erronous FnCode
 
 	/* Currently unsupported function codes
-		 * FnCode   NAME
-		 * 0x14     Read File Record
-		 * 0x15     Write File Record
-	     * 0x17     Read/Write Multiple registers
-	*/
+	 * FnCode   NAME
+	 * 0x2B     CANopen General Reference (MEI type 0x0D)
+	 */
 )
 
 type ExCode byte
@@ -63,6 +63,7 @@ const (
 	ServerDeviceFailure                ExCode = 4
 	Acknowledge                        ExCode = 5
 	ServerDeviceBusy                   ExCode = 6
+	NegativeAcknowledge                ExCode = 7
 	MemoryParityError                  ExCode = 8
 	GatewayPathUnavailable             ExCode = 10
 	GatewayTargetDeviceFailedToRespond ExCode = 11
@@ -76,11 +77,20 @@ var Exception map[ExCode]string = map[ExCode]string{
 	ServerDeviceFailure:                "Server device failure",
 	Acknowledge:                        "Acknowledge",
 	ServerDeviceBusy:                   "Server device busy",
+	NegativeAcknowledge:                "Negative acknowledge",
 	MemoryParityError:                  "Memory parity error",
 	GatewayPathUnavailable:             "Gateway path unavailable",
 	GatewayTargetDeviceFailedToRespond: "Gateway target device failed to respond",
 }
 
+// Error describes the exception code, which allows a DataModel to report exceptions as errors
+func (e ExCode) Error() string {
+	if description, found := Exception[e]; found {
+		return description
+	}
+	return fmt.Sprintf("Exception code %d", byte(e))
+}
+
 type Length int
 
 const (
@@ -90,6 +100,26 @@ const (
 	LCRC Length = 2
 )
 
+// Values and limits defined by the specification for read and write requests
+const (
+	CoilOn  uint16 = 0xFF00
+	CoilOff uint16 = 0x0000
+
+	MaxReadBits       uint16 = 0x07D0
+	MaxReadRegisters  uint16 = 0x007D
+	MaxWriteCoils     uint16 = 0x07B0
+	MaxWriteRegisters uint16 = 0x007B
+
+	MaxReadWriteRegisters uint16 = 0x0079
+
+	MaxFIFOCount uint16 = 0x001F
+
+	FileReferenceType   byte   = 0x06
+	MaxFileRecordNumber uint16 = 0x270F
+	MaxFileRecordBytes  byte   = 0xF5 // Byte count of a file record read request or response
+	MaxFileWriteBytes   byte   = 0xFB // Byte count of a file record write request
+)
+
 // Basic form of the modbus request, that aims to cover all possible modbus request forms
 type Request struct {
 	FnCode   FnCode
@@ -100,6 +130,26 @@ type Request struct {
 	SubFn    uint16
 	ANDMask  uint16
 	ORMask   uint16
+
+	WriteAddress uint16 // Relative address of the values written by a read/write request
+
+	Records []FileRecord // Sub-requests of a file record read or write
+
+	MEIType   byte // Interface encapsulated by an MEIT request
+	ReadDevId DeviceIdCode
+	ObjectId  ObjectId
+}
+
+/*
+ * FileRecord is a sub-request of a file record read or write, addressing a
+ * sequence of registers from a record number within a file. Reads request
+ * Length registers and writes carry their Values, which also set the length.
+ */
+type FileRecord struct {
+	File   uint16
+	Record uint16
+	Length uint16
+	Values []uint16
 }
 
 // NewRequest creates a new instance of a modbus Request class
@@ -107,7 +157,7 @@ func NewRequest(fncode FnCode, adr string, qty uint16) (*Request, error) {
 	var err error
 	var u64 uint64
 	if u64, err = strconv.ParseUint(adr, 10, 64); err != nil {
-		fmt.Errorf("Unable to parse address: %s", adr)
+		return nil, fmt.Errorf("Unable to parse address: %s", adr)
 	}
 	address, err := Relative(u64, &fncode)
 	if err != nil {
@@ -120,6 +170,29 @@ func NewRequest(fncode FnCode, adr string, qty uint16) (*Request, error) {
 	}, nil
 }
 
+// NewWriteRequest creates a modbus Request writing the values from an absolute address
+func NewWriteRequest(adr string, values []uint16) (*Request, error) {
+	var err error
+	var u64 uint64
+	if u64, err = strconv.ParseUint(adr, 10, 64); err != nil {
+		return nil, fmt.Errorf("Unable to parse address: %s", adr)
+	}
+	if len(values) < 1 || len(values) > 0xFFFF {
+		return nil, fmt.Errorf("Illegal number of values: %v", len(values))
+	}
+	var fncode FnCode
+	address, err := RelativeWrite(u64, uint16(len(values)), &fncode)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map address to function code: %s", err)
+	}
+	return &Request{
+		FnCode:   fncode,
+		Address:  uint16(address),
+		Quantity: uint16(len(values)),
+		Values:   values,
+	}, nil
+}
+
 // Function explicitly sets the function code of a modbus Request
 func Relative(absolute uint64, fncode *FnCode) (uint64, error) {
 	switch {
@@ -141,21 +214,51 @@ func Relative(absolute uint64, fncode *FnCode) (uint64, error) {
 	}
 }
 
+/*
+ * RelativeWrite sets the function code writing the quantity of coils or
+ * holding registers at an absolute address, and returns the relative
+ * address. Discrete inputs and input registers are read only.
+ */
+func RelativeWrite(absolute uint64, quantity uint16, fncode *FnCode) (uint64, error) {
+	switch {
+	case absolute <= 65535:
+		*fncode = WRMC
+		if quantity == 1 {
+			*fncode = WRSC
+		}
+		return absolute, nil
+	case absolute >= 100000 && absolute <= 165535:
+		*fncode = FERR
+		return absolute, fmt.Errorf("Unable to write read only discrete input: %v", absolute)
+	case absolute >= 300000 && absolute <= 365535:
+		*fncode = FERR
+		return absolute, fmt.Errorf("Unable to write read only input register: %v", absolute)
+	case absolute >= 400000 && absolute <= 465535:
+		*fncode = WRMR
+		if quantity == 1 {
+			*fncode = WRSR
+		}
+		return absolute - 400000, nil
+	default:
+		*fncode = FERR
+		return absolute, fmt.Errorf("Unable to convert address to relative: %v", absolute)
+	}
+}
+
 // Function explicitly sets the function code of a modbus Request
 func Absolute(fncode FnCode, relative uint64) (uint64, error) {
 	switch fncode {
-	case RDCO:
+	case RDCO, WRSC, WRMC:
 		return relative, nil
 	case RDDI:
 		return relative + 100000, nil
 	case RDIR:
 		return relative + 300000, nil
-	case RDHR:
+	case RDHR, WRSR, WRMR:
 		return relative + 400000, nil
 	default:
 		return relative, fmt.Errorf("Unable to convert address to absolute value: %v", relative)
 	}
-	return relative, nil
 }
 
 // Function explicitly sets the function code of a modbus Request
@@ -172,21 +275,190 @@ func (r *Request) Encode() (*PDU, error) {
 	pdu, err := NewPDU(r.FnCode)
 	switch r.FnCode {
 	// Build the request for each function code individually or as groups for similar requests
-	case RSID:
-		// Requesting slave ID
-		// A serial line only function code option
+	case RSID, RDES, GCEC, GCEL:
+		// Requesting slave ID, exception status or the comm event counter or log
+		// Serial line only function code options
 		pdu.Data = make([]byte, 0)
+	case DIAG:
+		// Sub-function followed by its data, a single word unless returning query data
+		values := r.Values
+		if len(values) == 0 {
+			values = []uint16{0}
+		}
+		if len(values) > MaxDiagnosticData {
+			return &PDU{}, fmt.Errorf("Illegal quantity of diagnostic data: %v", len(values))
+		}
+		pdu.Data = make([]byte, 2+2*len(values))
+		binary.BigEndian.PutUint16(pdu.Data, r.SubFn)
+		for idx, value := range values {
+			binary.BigEndian.PutUint16(pdu.Data[2+2*idx:], value)
+		}
 	case RDCO, RDDI, RDHR, RDIR:
 		// Requesting coils, discrete inputs, input and holding registers
 		pdu.Data = make([]byte, 4)
 		binary.BigEndian.PutUint16(pdu.Data, r.Address)
 		binary.BigEndian.PutUint16(pdu.Data[2:], r.Quantity)
+	case WRSC:
+		// Writing a single coil, where ON is encoded as 0xFF00 and OFF as 0x0000
+		if len(r.Values) != 1 {
+			return &PDU{}, fmt.Errorf("Illegal number of values for single coil: %v", len(r.Values))
+		}
+		pdu.Data = make([]byte, 4)
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		if r.Values[0] != 0 {
+			binary.BigEndian.PutUint16(pdu.Data[2:], CoilOn)
+		} else {
+			binary.BigEndian.PutUint16(pdu.Data[2:], CoilOff)
+		}
+	case WRSR:
+		// Writing a single holding register
+		if len(r.Values) != 1 {
+			return &PDU{}, fmt.Errorf("Illegal number of values for single register: %v", len(r.Values))
+		}
+		pdu.Data = make([]byte, 4)
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		binary.BigEndian.PutUint16(pdu.Data[2:], r.Values[0])
+	case WRMC:
+		// Writing multiple coils, packed eight to a byte
+		if r.Quantity < 1 || r.Quantity > MaxWriteCoils || int(r.Quantity) != len(r.Values) {
+			return &PDU{}, fmt.Errorf("Illegal quantity of coils: %v (%v values)", r.Quantity, len(r.Values))
+		}
+		r.Count = byte((r.Quantity + 7) / 8)
+		pdu.Data = make([]byte, 5+int(r.Count))
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		binary.BigEndian.PutUint16(pdu.Data[2:], r.Quantity)
+		pdu.Data[4] = r.Count
+		coils := make([]bool, len(r.Values))
+		for idx, value := range r.Values {
+			coils[idx] = value != 0
+		}
+		copy(pdu.Data[5:], packBits(coils))
+	case WRMR:
+		// Writing multiple holding registers, each register transmitted big endian
+		if r.Quantity < 1 || r.Quantity > MaxWriteRegisters || int(r.Quantity) != len(r.Values) {
+			return &PDU{}, fmt.Errorf("Illegal quantity of registers: %v (%v values)", r.Quantity, len(r.Values))
+		}
+		r.Count = byte(r.Quantity * 2)
+		pdu.Data = make([]byte, 5+int(r.Count))
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		binary.BigEndian.PutUint16(pdu.Data[2:], r.Quantity)
+		pdu.Data[4] = r.Count
+		for idx, value := range r.Values {
+			binary.BigEndian.PutUint16(pdu.Data[5+2*idx:], value)
+		}
+	case MWRR:
+		// Masking a single holding register, the result being (value AND and) OR (or AND NOT and)
+		pdu.Data = make([]byte, 6)
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		binary.BigEndian.PutUint16(pdu.Data[2:], r.ANDMask)
+		binary.BigEndian.PutUint16(pdu.Data[4:], r.ORMask)
+	case RWMR:
+		// Writing holding registers from the write address, then reading the quantity from the address
+		if r.Quantity < 1 || r.Quantity > MaxReadRegisters {
+			return &PDU{}, fmt.Errorf("Illegal quantity of registers read: %v", r.Quantity)
+		}
+		if len(r.Values) < 1 || len(r.Values) > int(MaxReadWriteRegisters) {
+			return &PDU{}, fmt.Errorf("Illegal quantity of registers written: %v", len(r.Values))
+		}
+		r.Count = byte(len(r.Values) * 2)
+		pdu.Data = make([]byte, 9+int(r.Count))
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
+		binary.BigEndian.PutUint16(pdu.Data[2:], r.Quantity)
+		binary.BigEndian.PutUint16(pdu.Data[4:], r.WriteAddress)
+		binary.BigEndian.PutUint16(pdu.Data[6:], uint16(len(r.Values)))
+		pdu.Data[8] = r.Count
+		for idx, value := range r.Values {
+			binary.BigEndian.PutUint16(pdu.Data[9+2*idx:], value)
+		}
+	case RDFR:
+		// Reading sub-requests of reference type, file number, record number and record length
+		if len(r.Records) < 1 || len(r.Records)*7 > int(MaxFileRecordBytes) {
+			return &PDU{}, fmt.Errorf("Illegal number of file record sub-requests: %v", len(r.Records))
+		}
+		// The response carries a length and reference type ahead of the registers of each sub-request
+		size := 0
+		for _, record := range r.Records {
+			if err = record.check(record.Length); err != nil {
+				return &PDU{}, err
+			}
+			size += 2 + 2*int(record.Length)
+		}
+		if size > int(MaxFileRecordBytes) {
+			return &PDU{}, fmt.Errorf("File record response exceeds %v bytes: %v", MaxFileRecordBytes, size)
+		}
+		r.Count = byte(len(r.Records) * 7)
+		pdu.Data = make([]byte, 1, 1+int(r.Count))
+		pdu.Data[0] = r.Count
+		for _, record := range r.Records {
+			pdu.Data = append(pdu.Data, record.header(record.Length)...)
+		}
+	case WRFR:
+		// Writing sub-requests of reference type, file number, record number, record length and data
+		if len(r.Records) < 1 {
+			return &PDU{}, fmt.Errorf("Illegal number of file record sub-requests: %v", len(r.Records))
+		}
+		size := 0
+		for _, record := range r.Records {
+			if err = record.check(uint16(len(record.Values))); err != nil {
+				return &PDU{}, err
+			}
+			size += 7 + 2*len(record.Values)
+		}
+		if size > int(MaxFileWriteBytes) {
+			return &PDU{}, fmt.Errorf("File record request exceeds %v bytes: %v", MaxFileWriteBytes, size)
+		}
+		r.Count = byte(size)
+		pdu.Data = make([]byte, 1, 1+size)
+		pdu.Data[0] = r.Count
+		for _, record := range r.Records {
+			pdu.Data = append(pdu.Data, record.header(uint16(len(record.Values)))...)
+			for _, value := range record.Values {
+				pdu.Data = append(pdu.Data, byte(value>>8), byte(value))
+			}
+		}
+	case MEIT:
+		// Only the read device identification interface is encapsulated
+		if r.MEIType != MEIDeviceId {
+			return &PDU{}, fmt.Errorf("Unsupported MEI type: %#x", r.MEIType)
+		}
+		if r.ReadDevId < BasicDeviceId || r.ReadDevId > IndividualDeviceId {
+			return &PDU{}, fmt.Errorf("Illegal read device id code: %v", r.ReadDevId)
+		}
+		pdu.Data = []byte{r.MEIType, byte(r.ReadDevId), byte(r.ObjectId)}
+	case FIFO:
+		// Reading the queue of registers behind the FIFO pointer address
+		pdu.Data = make([]byte, 2)
+		binary.BigEndian.PutUint16(pdu.Data, r.Address)
 	default:
-		return &PDU{}, fmt.Errorf("Unsupported function code: %s", r.FnCode)
+		return &PDU{}, fmt.Errorf("Unsupported function code: %v", r.FnCode)
 	}
 	return pdu, err
 }
 
+// check validates the addressing and register count of a file record sub-request
+func (f *FileRecord) check(length uint16) error {
+	if f.File < 1 {
+		return fmt.Errorf("Illegal file number: %v", f.File)
+	}
+	if f.Record > MaxFileRecordNumber {
+		return fmt.Errorf("Illegal file record number: %v", f.Record)
+	}
+	if length < 1 {
+		return fmt.Errorf("Illegal file record length: %v", length)
+	}
+	return nil
+}
+
+// header encodes the reference type, file number, record number and record length of a sub-request
+func (f *FileRecord) header(length uint16) []byte {
+	data := make([]byte, 7)
+	data[0] = FileReferenceType
+	binary.BigEndian.PutUint16(data[1:], f.File)
+	binary.BigEndian.PutUint16(data[3:], f.Record)
+	binary.BigEndian.PutUint16(data[5:], length)
+	return data
+}
+
 // Application Data Unit
 /*
  * The ADU structure should contain all possible values represented
@@ -202,10 +474,12 @@ type ADU struct {
 
 	Err []byte
 	CRC uint16
+	LRC byte
 
 	ExceptionCode ExCode
 	Exception     string
 
+	// Deprecated: timeouts are reported as ErrTimeout rather than flagged on an ADU
 	Timeout bool
 
 	PDU
@@ -259,27 +533,18 @@ func (d *ADU) ErrorCRC() error {
 	return nil
 }
 
-// ErrorCheck calculates and assigns the error checking mechanism (CRC) to the ADU
+// ErrorLRC calculates and assigns the error checking mechanism (LRC) to the ADU
 func (d *ADU) ErrorLRC() error {
-	var err error
-	var aduBytes []byte
+	var lrc LRC
 	/*
-	 * Force to nil to ensure that aduBytes only contain the relevant
-	 * information over which implement the error checking.
+	 * The LRC is calculated over the binary slave id and PDU only,
+	 * excluding the start and end of frame characters.
 	 */
 	d.Err = nil
-	if aduBytes, err = d.Bytes(); err != nil {
-		return fmt.Errorf("Unable to serialize ADU: %s", err)
-	}
-	if aduBytes == nil {
-
-	}
-	/*
-	 * Implement the error checking for an ADU.
-	 * This function is only called if error checking is
-	 * supported by the client's associated protocol.
-	 */
-
+	lrc.reset().calculate(d.Hdr).calculate(d.FnCode).calculate(d.Data)
+	// Assign LRC to the corresponding ADU member and error checking slice
+	d.LRC = lrc.value()
+	d.Err = []byte{d.LRC}
 	return nil
 }
 
@@ -344,3 +609,23 @@ func NewPDU(fncode FnCode) (*PDU, error) {
 		FnCode: []byte{byte(fncode)},
 	}, nil
 }
+
+// packBits packs coils eight to a byte with the first coil in the least significant bit
+func packBits(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for idx, value := range values {
+		if value {
+			packed[idx/8] |= 1 << uint(idx%8)
+		}
+	}
+	return packed
+}
+
+// unpackBits extracts quantity coils packed eight to a byte
+func unpackBits(packed []byte, quantity int) []bool {
+	values := make([]bool, quantity)
+	for idx := range values {
+		values[idx] = packed[idx/8]&(1<<uint(idx%8)) != 0
+	}
+	return values
+}
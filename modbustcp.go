@@ -1,6 +1,7 @@
 package modbusd
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
@@ -16,6 +17,9 @@ const (
 	LLEN  Length = 2
 )
 
+// Maximum value of the MBAP length field, which includes the unit id, for a 260 byte frame
+const MaxMBAPLength uint16 = 254
+
 type ModbusTCP struct {
 	MBAP
 	ProtocolBase
@@ -55,7 +59,7 @@ func (m *ModbusTCP) Encode(pdu *PDU) (*ADU, error) {
 	// Calculate length to include the Slave Id, Function Code and PDU Data payload
 	adu.Length = uint16(LSID) + uint16(LFNC) + uint16(len(pdu.Data))
 	if adu.Length <= 0 {
-		return nil, fmt.Errorf("Invalid protocol length member: %v", adu.Length)
+		return nil, fmt.Errorf("%w: Invalid protocol length member: %v", ErrFrame, adu.Length)
 	}
 	binary.BigEndian.PutUint16(adu.Hdr[(LTID+LPID):], adu.Length)
 
@@ -67,6 +71,42 @@ func (m *ModbusTCP) Encode(pdu *PDU) (*ADU, error) {
 	return adu, err
 }
 
+// Reply builds the MBAP header of a response, echoing the transaction of the request
+func (m *ModbusTCP) Reply(request *ADU, pdu *PDU) (*ADU, error) {
+	adu, err := NewADU(pdu)
+	if err != nil {
+		return nil, err
+	}
+	adu.TransactionId = request.TransactionId
+	adu.ProtocolId = request.ProtocolId
+	adu.Length = uint16(LSID) + uint16(LFNC) + uint16(len(pdu.Data))
+	adu.SlaveId = request.SlaveId
+	adu.Hdr = make([]byte, LMBAP)
+	binary.BigEndian.PutUint16(adu.Hdr, adu.TransactionId)
+	binary.BigEndian.PutUint16(adu.Hdr[LTID:], adu.ProtocolId)
+	binary.BigEndian.PutUint16(adu.Hdr[(LTID+LPID):], adu.Length)
+	adu.Hdr[(LTID+LPID)+LLEN] = adu.SlaveId
+	return adu, nil
+}
+
+/*
+ * Match verifies the MBAP header of a response against its request. A response
+ * to another transaction is stale, typically the late answer to a request that
+ * timed out, and is reported as not matched.
+ */
+func (m *ModbusTCP) Match(request *ADU, response *ADU) (bool, error) {
+	if response.TransactionId != request.TransactionId {
+		return false, nil
+	}
+	if response.ProtocolId != request.ProtocolId {
+		return false, fmt.Errorf("Protocol id mismatch %v!=%v", response.ProtocolId, request.ProtocolId)
+	}
+	if response.SlaveId != request.SlaveId {
+		return false, fmt.Errorf("Unit id mismatch %v!=%v", response.SlaveId, request.SlaveId)
+	}
+	return true, nil
+}
+
 // Decode calls on the ProtocolBAse function Recover to decode received ModbusTCP protocol messages
 func (m *ModbusTCP) Decode(response []byte) (*ADU, error) {
 	adu, err := m.Recover(response, SMBAP)
@@ -76,3 +116,50 @@ func (m *ModbusTCP) Decode(response []byte) (*ADU, error) {
 	return adu, nil
 }
 
+// DecodeRequest calls on the ProtocolBase function RecoverRequest to decode ModbusTCP requests received by a slave
+func (m *ModbusTCP) DecodeRequest(request []byte) (*ADU, error) {
+	adu, err := m.RecoverRequest(request, SMBAP)
+	if err != nil {
+		return nil, err
+	}
+	if adu.ProtocolId != ModbusTCPProtocolId {
+		return nil, fmt.Errorf("Unsupported protocol id: %v", adu.ProtocolId)
+	}
+	return adu, nil
+}
+
+/*
+ * Assemble collects a complete ModbusTCP frame in the transport buffer using
+ * the length field of the MBAP header. Bytes received beyond the frame, such
+ * as a following response in the same segment, remain buffered for the next
+ * frame.
+ */
+func (m *ModbusTCP) Assemble(t Transport) (int, error) {
+	return m.AssembleContext(context.Background(), t)
+}
+
+// AssembleContext collects a complete ModbusTCP frame, giving up once the context is done
+func (m *ModbusTCP) AssembleContext(ctx context.Context, t Transport) (int, error) {
+	for {
+		t.Lock()
+		buffer := t.Buffer()
+		t.Unlock()
+		if len(buffer) >= int(LMBAP) {
+			length := binary.BigEndian.Uint16(buffer[(LTID + LPID):])
+			if length < uint16(LSID+LFNC) || length > MaxMBAPLength {
+				// The stream can not be resynchronised once a length is corrupt
+				t.Lock()
+				t.Flush()
+				t.Unlock()
+				return 0, fmt.Errorf("%w: Illegal MBAP length: %v", ErrFrame, length)
+			}
+			// The unit id is counted in both the header and the length
+			if flen := int(LMBAP) + int(length) - int(LSID); len(buffer) >= flen {
+				return flen, nil
+			}
+		}
+		if err := t.ListenContext(ctx); err != nil {
+			return 0, err
+		}
+	}
+}
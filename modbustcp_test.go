@@ -0,0 +1,133 @@
+package modbusd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// typedCall runs a typed read or write of the client, returning the values read if any
+type typedCall func(c *Client) (interface{}, error)
+
+func TestModbusTCPTyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		exchange exchange
+		call     typedCall
+		values   interface{}
+	}{
+		{
+			"ReadCoils",
+			exchange{"0000 0000 0006 01 01 0013 000A", "0000 0000 0005 01 01 02 CD 01"},
+			func(c *Client) (interface{}, error) { return c.ReadCoils(0x13, 10) },
+			[]bool{true, false, true, true, false, false, true, true, true, false},
+		},
+		{
+			"ReadDiscreteInputs",
+			exchange{"0001 0000 0006 01 02 00C4 0003", "0001 0000 0004 01 02 01 05"},
+			func(c *Client) (interface{}, error) { return c.ReadDiscreteInputs(0xC4, 3) },
+			[]bool{true, false, true},
+		},
+		{
+			"ReadHoldingRegisters",
+			exchange{"0002 0000 0006 01 03 006B 0002", "0002 0000 0007 01 03 04 022B 0000"},
+			func(c *Client) (interface{}, error) { return c.ReadHoldingRegisters(0x6B, 2) },
+			[]uint16{0x022B, 0x0000},
+		},
+		{
+			"ReadInputRegisters",
+			exchange{"0003 0000 0006 01 04 0008 0001", "0003 0000 0005 01 04 02 000A"},
+			func(c *Client) (interface{}, error) { return c.ReadInputRegisters(8, 1) },
+			[]uint16{0x000A},
+		},
+		{
+			"WriteCoil",
+			exchange{"0004 0000 0006 01 05 00AC FF00", "0004 0000 0006 01 05 00AC FF00"},
+			func(c *Client) (interface{}, error) { return nil, c.WriteCoil(0xAC, true) },
+			nil,
+		},
+		{
+			"WriteRegister",
+			exchange{"0005 0000 0006 01 06 0001 0003", "0005 0000 0006 01 06 0001 0003"},
+			func(c *Client) (interface{}, error) { return nil, c.WriteRegister(1, 3) },
+			nil,
+		},
+		{
+			"WriteCoils",
+			exchange{"0006 0000 0009 01 0F 0013 000A 02 CD 01", "0006 0000 0006 01 0F 0013 000A"},
+			func(c *Client) (interface{}, error) {
+				return nil, c.WriteCoils(0x13, []bool{true, false, true, true, false, false, true, true, true, false})
+			},
+			nil,
+		},
+		{
+			"WriteRegisters",
+			exchange{"0007 0000 000B 01 10 0001 0002 04 000A 0102", "0007 0000 0006 01 10 0001 0002"},
+			func(c *Client) (interface{}, error) { return nil, c.WriteRegisters(1, []uint16{0x000A, 0x0102}) },
+			nil,
+		},
+	}
+	protocol, _ := NewModbusTCP(1)
+	c := fakeClient(t, protocol)
+	transport := c.Transport.(*fakeTransport)
+	for _, test := range tests {
+		transport.exchanges = append(transport.exchanges, test.exchange)
+	}
+	for _, test := range tests {
+		values, err := test.call(c)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if test.values != nil && !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s = %v; want %v", test.name, values, test.values)
+		}
+	}
+}
+
+func TestModbusTCPMatching(t *testing.T) {
+	protocol, _ := NewModbusTCP(1)
+	c := fakeClient(t, protocol,
+		// The late response to an earlier transaction precedes the response, in the same segment or not
+		exchange{"0000 0000 0006 01 03 0000 0001", "FFFF 0000 0005 01 03 02 0001 0000 0000 0005 01 03 02 0002"},
+		exchange{"0001 0000 0006 01 03 0000 0001", "0000 0000 0005 01 03 02 0001 | 0001 0000 0005 01 03 02 0003"},
+		// Only a stale response arrives
+		exchange{"0002 0000 0006 01 03 0000 0001", "0001 0000 0005 01 03 02 0003"},
+		exchange{"0003 0000 0006 01 03 0000 0001", "0003 0001 0005 01 03 02 0004"},
+		exchange{"0004 0000 0006 01 03 0000 0001", "0004 0000 0005 02 03 02 0005"},
+	)
+	for _, expected := range []uint16{2, 3} {
+		if values, err := c.ReadHoldingRegisters(0, 1); err != nil || values[0] != expected {
+			t.Errorf("ReadHoldingRegisters after a stale response = %v, %v; want %v", values, err, expected)
+		}
+	}
+	if _, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrTimeout) {
+		t.Errorf("ReadHoldingRegisters with only a stale response = %v; want %v", err, ErrTimeout)
+	}
+	if _, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrResponse) {
+		t.Errorf("ReadHoldingRegisters of another protocol id = %v; want %v", err, ErrResponse)
+	}
+	if _, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrResponse) {
+		t.Errorf("ReadHoldingRegisters of another unit id = %v; want %v", err, ErrResponse)
+	}
+}
+
+func TestModbusTCPMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"MBAP length without a function code", "0000 0000 0001 01"},
+		{"MBAP length beyond the maximum", "0000 0000 00FF 01 03 02 0001"},
+		{"byte count beyond the frame", "0000 0000 0005 01 03 04 0001"},
+		{"byte count short of the frame", "0000 0000 0007 01 03 02 0001 0002"},
+		{"exception without a code", "0000 0000 0002 01 83"},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		c := fakeClient(t, protocol, exchange{"0000 0000 0006 01 03 0000 0001", test.response})
+		if values, err := c.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrFrame) {
+			t.Errorf("ReadHoldingRegisters of a %s = %v, %v; want %v", test.name, values, err, ErrFrame)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package modbusd
+
+import (
+	"sync"
+)
+
+/*
+ * DataModel is the data a slave exposes to its masters. Addresses are
+ * relative to the start of each table. Implementations report failures by
+ * returning an ExCode, e.g. IllegalDataAddress for addresses out of range;
+ * any other error is reported to the master as a ServerDeviceFailure.
+ */
+type DataModel interface {
+	ReadCoils(address uint16, quantity uint16) ([]bool, error)
+	ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error)
+	ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error)
+	ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error)
+	WriteCoils(address uint16, values []bool) error
+	WriteHoldingRegisters(address uint16, values []uint16) error
+	// MaskWriteRegister sets a holding register to (value AND and) OR (or AND NOT and) atomically
+	MaskWriteRegister(address uint16, and uint16, or uint16) error
+}
+
+// Memory is an in-memory DataModel, safe for concurrent use by slaves and the application
+type Memory struct {
+	M                sync.RWMutex
+	Coils            []bool
+	DiscreteInputs   []bool
+	HoldingRegisters []uint16
+	InputRegisters   []uint16
+}
+
+// NewMemory creates an instance of the Memory class with the number of items in each table
+func NewMemory(coils int, discretes int, holding int, input int) *Memory {
+	return &Memory{
+		Coils:            make([]bool, coils),
+		DiscreteInputs:   make([]bool, discretes),
+		HoldingRegisters: make([]uint16, holding),
+		InputRegisters:   make([]uint16, input),
+	}
+}
+
+// inRange verifies that quantity items from address fall within a table of size items
+func inRange(address uint16, quantity int, size int) error {
+	if int(address)+quantity > size {
+		return IllegalDataAddress
+	}
+	return nil
+}
+
+// ReadCoils reads consecutive coils starting at the address
+func (m *Memory) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	m.M.RLock()
+	defer m.M.RUnlock()
+	if err := inRange(address, int(quantity), len(m.Coils)); err != nil {
+		return nil, err
+	}
+	return append([]bool{}, m.Coils[address:int(address)+int(quantity)]...), nil
+}
+
+// ReadDiscreteInputs reads consecutive discrete inputs starting at the address
+func (m *Memory) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	m.M.RLock()
+	defer m.M.RUnlock()
+	if err := inRange(address, int(quantity), len(m.DiscreteInputs)); err != nil {
+		return nil, err
+	}
+	return append([]bool{}, m.DiscreteInputs[address:int(address)+int(quantity)]...), nil
+}
+
+// ReadHoldingRegisters reads consecutive holding registers starting at the address
+func (m *Memory) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	m.M.RLock()
+	defer m.M.RUnlock()
+	if err := inRange(address, int(quantity), len(m.HoldingRegisters)); err != nil {
+		return nil, err
+	}
+	return append([]uint16{}, m.HoldingRegisters[address:int(address)+int(quantity)]...), nil
+}
+
+// ReadInputRegisters reads consecutive input registers starting at the address
+func (m *Memory) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	m.M.RLock()
+	defer m.M.RUnlock()
+	if err := inRange(address, int(quantity), len(m.InputRegisters)); err != nil {
+		return nil, err
+	}
+	return append([]uint16{}, m.InputRegisters[address:int(address)+int(quantity)]...), nil
+}
+
+// WriteCoils sets consecutive coils starting at the address
+func (m *Memory) WriteCoils(address uint16, values []bool) error {
+	m.M.Lock()
+	defer m.M.Unlock()
+	if err := inRange(address, len(values), len(m.Coils)); err != nil {
+		return err
+	}
+	copy(m.Coils[address:], values)
+	return nil
+}
+
+// WriteHoldingRegisters sets consecutive holding registers starting at the address
+func (m *Memory) WriteHoldingRegisters(address uint16, values []uint16) error {
+	m.M.Lock()
+	defer m.M.Unlock()
+	if err := inRange(address, len(values), len(m.HoldingRegisters)); err != nil {
+		return err
+	}
+	copy(m.HoldingRegisters[address:], values)
+	return nil
+}
+
+// MaskWriteRegister modifies the bits of a holding register selected by the masks
+func (m *Memory) MaskWriteRegister(address uint16, and uint16, or uint16) error {
+	m.M.Lock()
+	defer m.M.Unlock()
+	if err := inRange(address, 1, len(m.HoldingRegisters)); err != nil {
+		return err
+	}
+	m.HoldingRegisters[address] = (m.HoldingRegisters[address] & and) | (or &^ and)
+	return nil
+}
@@ -0,0 +1,183 @@
+package modbusd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Result of a multiplexed transaction handed from the reader to the waiting caller
+type muxResult struct {
+	response *ADU
+	err      error
+}
+
+/*
+ * Mux multiplexes concurrent ModbusTCP transactions over a single TCP
+ * connection. Up to a window of requests are outstanding at any time, and
+ * a background reader dispatches each response to the caller waiting on
+ * its transaction id.
+ */
+type Mux struct {
+	tcp      *TCP
+	protocol *ModbusTCP
+	window   chan struct{}
+	w        sync.Mutex // Serialises the writes to the connection
+
+	m       sync.Mutex
+	pending map[uint16]chan muxResult
+	err     error
+	done    chan struct{}
+}
+
+// NewMux creates an instance of the Mux class and starts reading from the connected transport
+func NewMux(tcp *TCP, protocol *ModbusTCP, window int) (*Mux, error) {
+	if window < 1 || window > 0xFFFF {
+		return nil, fmt.Errorf("Illegal transaction window: %v", window)
+	}
+	if tcp.Conn == nil {
+		return nil, fmt.Errorf("Transport not connected: %s", tcp.URL)
+	}
+	// Responses are awaited per transaction rather than per connection
+	if err := tcp.Conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	m := &Mux{
+		tcp:      tcp,
+		protocol: protocol,
+		window:   make(chan struct{}, window),
+		pending:  make(map[uint16]chan muxResult),
+		done:     make(chan struct{}),
+	}
+	go m.read()
+	return m, nil
+}
+
+// do sends a request once a slot in the window is free and waits for its response, unless the context is done first
+func (m *Mux) do(ctx context.Context, pdu *PDU) (*ADU, error) {
+	select {
+	case m.window <- struct{}{}:
+	case <-m.done:
+		return nil, m.failure()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-m.window }()
+
+	// Transaction ids are assigned and frames written in the same order
+	result := make(chan muxResult, 1)
+	m.w.Lock()
+	m.m.Lock()
+	if m.err != nil {
+		m.m.Unlock()
+		m.w.Unlock()
+		return nil, m.err
+	}
+	request, err := m.protocol.Encode(pdu)
+	if err != nil {
+		m.m.Unlock()
+		m.w.Unlock()
+		return nil, fmt.Errorf("Unable to encode the PDU: %#v, %s", pdu, err)
+	}
+	requestBytes, err := request.Bytes()
+	if err != nil {
+		m.m.Unlock()
+		m.w.Unlock()
+		return nil, fmt.Errorf("Unable to encode the ADU: %s", err)
+	}
+	m.pending[request.TransactionId] = result
+	m.m.Unlock()
+
+	if err = m.tcp.Conn.SetWriteDeadline(time.Now().Add(m.tcp.Timeout)); err == nil {
+		// Only the write is interrupted, the reader serves the other transactions
+		stop := interrupt(ctx, m.tcp.Conn.SetWriteDeadline)
+		_, err = m.tcp.Conn.Write(requestBytes)
+		stop()
+	}
+	m.w.Unlock()
+	if err != nil {
+		m.m.Lock()
+		delete(m.pending, request.TransactionId)
+		m.m.Unlock()
+		/*
+		 * A partially written frame corrupts the stream for all
+		 * transactions, so the connection is failed.
+		 */
+		m.fail(fmt.Errorf("%w: Request failed: %s", ErrClosed, err))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("Request failed: %w", err)
+	}
+
+	timer := time.NewTimer(m.tcp.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		if r.err != nil {
+			return nil, fmt.Errorf("Unable to decode response: %w", r.err)
+		}
+		if _, err = m.protocol.Match(request, r.response); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrResponse, err)
+		}
+		return r.response, nil
+	case <-timer.C:
+		// A response arriving after this point is stale and discarded by the reader
+		m.m.Lock()
+		delete(m.pending, request.TransactionId)
+		m.m.Unlock()
+		return nil, fmt.Errorf("%w: Transaction %v", ErrTimeout, request.TransactionId)
+	case <-m.done:
+		return nil, m.failure()
+	case <-ctx.Done():
+		// The response is discarded by the reader when it arrives
+		m.m.Lock()
+		delete(m.pending, request.TransactionId)
+		m.m.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// read dispatches responses to the callers waiting on their transactions until the connection fails
+func (m *Mux) read() {
+	for {
+		frame, err := readMBAP(m.tcp.Conn)
+		if err != nil {
+			m.fail(fmt.Errorf("%w: Transport unable to listen: %s", ErrClosed, err))
+			return
+		}
+		response, err := m.protocol.Decode(frame)
+		tid := binary.BigEndian.Uint16(frame)
+		m.m.Lock()
+		result, found := m.pending[tid]
+		delete(m.pending, tid)
+		m.m.Unlock()
+		if found {
+			result <- muxResult{response: response, err: err}
+		}
+	}
+}
+
+// fail records the failure of the connection and releases all waiting callers
+func (m *Mux) fail(err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.err == nil {
+		m.err = err
+		close(m.done)
+	}
+}
+
+func (m *Mux) failure() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.err
+}
+
+// Close closes the connection, failing any outstanding transactions
+func (m *Mux) Close() error {
+	m.fail(ErrClosed)
+	return m.tcp.Close()
+}
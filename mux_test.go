@@ -0,0 +1,192 @@
+package modbusd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// multiplexed connects a client to the address and switches it to pipelined mode
+func multiplexed(t *testing.T, address *net.TCPAddr, timeout time.Duration, window int) *Client {
+	transport, _ := NewTCP(address.IP.String(), uint16(address.Port), timeout)
+	protocol, _ := NewModbusTCP(1)
+	c := &Client{Protocol: protocol, Transport: transport}
+	if err := c.Multiplex(window); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+/*
+ * scriptedDevice accepts a single connection and hands each request frame to
+ * the reply function on its own goroutine, so that requests are answered out
+ * of order, late or not at all.
+ */
+func scriptedDevice(t *testing.T, reply func(conn net.Conn, frame []byte)) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var replies sync.WaitGroup
+		defer replies.Wait()
+		for {
+			frame, err := readMBAP(conn)
+			if err != nil {
+				return
+			}
+			replies.Add(1)
+			go func() {
+				defer replies.Done()
+				reply(conn, frame)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+// echoRegister builds the response to a single register read returning the requested address
+func echoRegister(frame []byte) []byte {
+	response := make([]byte, len(frame))
+	copy(response, frame)
+	binary.BigEndian.PutUint16(response[LTID+LPID:], 5)
+	response[LMBAP] = byte(RDHR)
+	response[LMBAP+1] = 2
+	copy(response[LMBAP+2:], frame[LMBAP+1:LMBAP+3])
+	return response[:LMBAP+4]
+}
+
+// requestAddress returns the start address of a read request
+func requestAddress(frame []byte) uint16 {
+	return binary.BigEndian.Uint16(frame[LMBAP+1:])
+}
+
+func TestMuxConcurrent(t *testing.T) {
+	memory := NewMemory(10, 10, 100, 10)
+	server, err := NewServer(memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	c := multiplexed(t, listener.Addr().(*net.TCPAddr), time.Second, 4)
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 50; idx++ {
+		wg.Add(1)
+		go func(address uint16) {
+			defer wg.Done()
+			if err := c.WriteRegister(address, 1000+address); err != nil {
+				t.Errorf("WriteRegister(%v): %s", address, err)
+				return
+			}
+			if values, err := c.ReadHoldingRegisters(address, 1); err != nil || values[0] != 1000+address {
+				t.Errorf("ReadHoldingRegisters(%v) = %v, %v", address, values, err)
+			}
+		}(uint16(idx))
+	}
+	wg.Wait()
+}
+
+func TestMuxStaleResponses(t *testing.T) {
+	address := scriptedDevice(t, func(conn net.Conn, frame []byte) {
+		response := echoRegister(frame)
+		// A response to a transaction never requested
+		unknown := append([]byte{}, response...)
+		binary.BigEndian.PutUint16(unknown, binary.BigEndian.Uint16(frame)+0x8000)
+		conn.Write(unknown)
+		if requestAddress(frame) == 9 {
+			// Answered after the transaction timed out
+			time.Sleep(150 * time.Millisecond)
+		}
+		conn.Write(response)
+	})
+	c := multiplexed(t, address, 50*time.Millisecond, 4)
+
+	if _, err := c.ReadHoldingRegisters(9, 1); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ReadHoldingRegisters(9) = %v; want %v", err, ErrTimeout)
+	}
+	// The unknown and the late responses are discarded
+	for _, a := range []uint16{1, 2, 3} {
+		if values, err := c.ReadHoldingRegisters(a, 1); err != nil || values[0] != a {
+			t.Errorf("ReadHoldingRegisters(%v) = %v, %v", a, values, err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if values, err := c.ReadHoldingRegisters(4, 1); err != nil || values[0] != 4 {
+		t.Errorf("ReadHoldingRegisters(4) after a late response = %v, %v", values, err)
+	}
+}
+
+func TestMuxCancel(t *testing.T) {
+	address := scriptedDevice(t, func(conn net.Conn, frame []byte) {
+		if requestAddress(frame) != 9 {
+			conn.Write(echoRegister(frame))
+		}
+	})
+	c := multiplexed(t, address, time.Second, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := c.ReadHoldingRegistersContext(ctx, 9, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadHoldingRegistersContext = %v; want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Cancellation took %v", elapsed)
+	}
+	// The other transactions are unaffected
+	if values, err := c.ReadHoldingRegisters(2, 1); err != nil || values[0] != 2 {
+		t.Errorf("ReadHoldingRegisters(2) = %v, %v", values, err)
+	}
+}
+
+func TestMuxConnectionDrop(t *testing.T) {
+	address := scriptedDevice(t, func(conn net.Conn, frame []byte) {
+		if requestAddress(frame) == 9 {
+			conn.Close()
+		}
+	})
+	c := multiplexed(t, address, 5*time.Second, 4)
+
+	errc := make(chan error, 3)
+	for _, a := range []uint16{1, 2, 3} {
+		go func(a uint16) {
+			_, err := c.ReadHoldingRegisters(a, 1)
+			errc <- err
+		}(a)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.ReadHoldingRegisters(9, 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadHoldingRegisters(9) = %v; want %v", err, ErrClosed)
+	}
+	// All pending transactions fail at once rather than timing out
+	for range []uint16{1, 2, 3} {
+		select {
+		case err := <-errc:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Pending transaction = %v; want %v", err, ErrClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Pending transaction not failed")
+		}
+	}
+	if _, err := c.ReadHoldingRegisters(1, 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadHoldingRegisters after the drop = %v; want %v", err, ErrClosed)
+	}
+}
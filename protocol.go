@@ -1,6 +1,7 @@
 package modbusd
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
@@ -10,10 +11,38 @@ type Protocol interface {
 	Decode([]byte) (*ADU, error)
 }
 
+/*
+ * Assembler is implemented by protocols whose frames have to be collected
+ * from the transport before they can be decoded. Assemble returns the
+ * length of the complete frame at the start of the transport buffer.
+ * AssembleContext gives up as soon as the context is done.
+ */
+type Assembler interface {
+	Assemble(t Transport) (int, error)
+	AssembleContext(ctx context.Context, t Transport) (int, error)
+}
+
+/*
+ * Matcher is implemented by protocols that can correlate a response with its
+ * request. Responses to other requests are reported as not matched, while
+ * responses that can not be valid for the request return an error.
+ */
+type Matcher interface {
+	Match(request *ADU, response *ADU) (bool, error)
+}
+
 type ProtocolBase struct {
 	SlaveId byte
 }
 
+// Match verifies that the response originates from the slave the request was addressed to
+func (p *ProtocolBase) Match(request *ADU, response *ADU) (bool, error) {
+	if response.SlaveId != request.SlaveId {
+		return false, fmt.Errorf("Slave id mismatch %v!=%v", response.SlaveId, request.SlaveId)
+	}
+	return true, nil
+}
+
 type State int
 type Section int
 type Element int
@@ -54,6 +83,15 @@ const (
 
 // Recover implements a state machine that completely decodes all variations on the modbus protocol
 func (p *ProtocolBase) Recover(response []byte, section Section) (*ADU, error) {
+	return p.recover(response, section, false)
+}
+
+// RecoverRequest decodes a request received by a slave, leaving the request data undecoded in the ADU
+func (p *ProtocolBase) RecoverRequest(request []byte, section Section) (*ADU, error) {
+	return p.recover(request, section, true)
+}
+
+func (p *ProtocolBase) recover(response []byte, section Section, request bool) (*ADU, error) {
 	var err error
 	var adu *ADU
 	/*
@@ -64,7 +102,7 @@ func (p *ProtocolBase) Recover(response []byte, section Section) (*ADU, error) {
 	var start Section = section
 	var state State = TRESET
 	var element Element = ENONE
-	var cnt byte
+	var cnt int
 	var exitState, exitSection bool
 	for {
 		switch state {
@@ -134,7 +172,7 @@ func (p *ProtocolBase) Recover(response []byte, section Section) (*ADU, error) {
 						return nil, err
 					}
 				case SPDU:
-					if err = p.handlePDU(adu, response, &cnt, &start, &section, &element); err != nil {
+					if err = p.handlePDU(adu, response, request, &cnt, &start, &section, &element); err != nil {
 						return nil, err
 					}
 				case SERR:
@@ -188,17 +226,21 @@ func (p *ProtocolBase) Recover(response []byte, section Section) (*ADU, error) {
 	return nil, fmt.Errorf("Unable to process response")
 }
 
-func (p *ProtocolBase) handleSOF(adu *ADU, response []byte, start Section, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleSOF(adu *ADU, response []byte, start Section, cnt *int, section *Section, element *Element) error {
 	switch start {
 	case SMBAP:
 	case SRTU:
 	case SASCII:
-		adu.SOF[0] = response[*cnt]
+		if len(response) < int(LSOF+LEOF) {
+			*section = SFAIL
+			return fmt.Errorf("%w: Response too short at %v bytes", ErrFrame, len(response))
+		}
+		adu.SOF = []byte{response[*cnt]}
 		*cnt++
 		*section = SEOF
 		if adu.SOF[0] != COLON {
 			*section = SFAIL
-			return fmt.Errorf("Frame alignment error (SOF) : %v", adu.SOF)
+			return fmt.Errorf("%w: Frame alignment error (SOF) : %v", ErrFrame, adu.SOF)
 		}
 	default:
 		return fmt.Errorf("Protocol does not support a Start Of Frame : %v", start)
@@ -206,7 +248,7 @@ func (p *ProtocolBase) handleSOF(adu *ADU, response []byte, start Section, cnt *
 	return nil
 }
 
-func (p *ProtocolBase) handleEOF(adu *ADU, response []byte, start Section, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleEOF(adu *ADU, response []byte, start Section, cnt *int, section *Section, element *Element) error {
 	switch start {
 	case SMBAP:
 	case SRTU:
@@ -216,7 +258,7 @@ func (p *ProtocolBase) handleEOF(adu *ADU, response []byte, start Section, cnt *
 		*section = SERR
 		if adu.EOF[0] != CR || adu.EOF[1] != LF {
 			*section = SFAIL
-			return fmt.Errorf("Frame alignment error (EOF) : %v", adu.EOF)
+			return fmt.Errorf("%w: Frame alignment error (EOF) : %v", ErrFrame, adu.EOF)
 		}
 	default:
 		return fmt.Errorf("Protocol does not support a End Of Frame : %v", start)
@@ -224,8 +266,11 @@ func (p *ProtocolBase) handleEOF(adu *ADU, response []byte, start Section, cnt *
 	return nil
 }
 
-func (p *ProtocolBase) handleMBAP(adu *ADU, response []byte, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleMBAP(adu *ADU, response []byte, cnt *int, section *Section, element *Element) error {
 	var exitElement = false
+	if len(response) < int(LMBAP+LFNC) {
+		return fmt.Errorf("%w: Response header too short at %v bytes", ErrFrame, len(response))
+	}
 	*element = ETRANSID
 	adu.Hdr = make([]byte, LMBAP)
 	for {
@@ -234,17 +279,17 @@ func (p *ProtocolBase) handleMBAP(adu *ADU, response []byte, cnt *byte, section
 		case ETRANSID:
 			adu.TransactionId = binary.BigEndian.Uint16(response[*cnt:])
 			binary.BigEndian.PutUint16(adu.Hdr[*cnt:], adu.TransactionId)
-			*cnt = *cnt + byte(LTID)
+			*cnt = *cnt + int(LTID)
 			*element = EPROTOID
 		case EPROTOID:
 			adu.ProtocolId = binary.BigEndian.Uint16(response[*cnt:])
 			binary.BigEndian.PutUint16(adu.Hdr[*cnt:], adu.ProtocolId)
-			*cnt = *cnt + byte(LPID)
+			*cnt = *cnt + int(LPID)
 			*element = ELENGTH
 		case ELENGTH:
 			adu.Length = binary.BigEndian.Uint16(response[*cnt:])
 			binary.BigEndian.PutUint16(adu.Hdr[*cnt:], adu.Length)
-			*cnt = *cnt + byte(LLEN)
+			*cnt = *cnt + int(LLEN)
 			*element = ESLAVEID
 		case ESLAVEID:
 			adu.SlaveId = response[*cnt]
@@ -264,9 +309,9 @@ func (p *ProtocolBase) handleMBAP(adu *ADU, response []byte, cnt *byte, section
 	return nil
 }
 
-func (p *ProtocolBase) handleRTU(adu *ADU, response []byte, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleRTU(adu *ADU, response []byte, cnt *int, section *Section, element *Element) error {
 	if len(response) < int(LSID+LFNC+LCRC) {
-		return fmt.Errorf("Response header too short at %v bytes", len(response))
+		return fmt.Errorf("%w: Response header too short at %v bytes", ErrFrame, len(response))
 	}
 	adu.Hdr = make([]byte, 1)
 	*element = ESLAVEID
@@ -284,17 +329,17 @@ func (p *ProtocolBase) handleRTU(adu *ADU, response []byte, cnt *byte, section *
 	return nil
 }
 
-func (p *ProtocolBase) handleASCII(adu *ADU, response []byte, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleASCII(adu *ADU, response []byte, cnt *int, section *Section, element *Element) error {
 	if len(response) < int(LSOF+LSID+LFNC+LLRC+LEOF) {
-		return fmt.Errorf("Response header too short at %v bytes", len(response))
+		return fmt.Errorf("%w: Response header too short at %v bytes", ErrFrame, len(response))
 	}
 	adu.Hdr = make([]byte, 1)
 	*element = ESLAVEID
-	// The RTU header contains only a SlaveId and is parsed from the repsonse
+	// The ASCII header contains only a SlaveId and is parsed from the repsonse
 	switch *element {
 	case ESLAVEID:
 		adu.SlaveId = response[*cnt]
-		adu.Hdr[*cnt] = adu.SlaveId
+		adu.Hdr[0] = adu.SlaveId
 		*cnt++
 		// Skip out the the PDU processing section
 		*section = SPDU
@@ -304,7 +349,7 @@ func (p *ProtocolBase) handleASCII(adu *ADU, response []byte, cnt *byte, section
 	return nil
 }
 
-func (p *ProtocolBase) handleCRC(adu *ADU, response []byte, cnt *byte, section *Section, element *Element) error {
+func (p *ProtocolBase) handleCRC(adu *ADU, response []byte, cnt *int, section *Section, element *Element) error {
 	/*
 	 * Only protocols that employ this error checking mechanism
 	 * will call on this switch element.
@@ -312,7 +357,7 @@ func (p *ProtocolBase) handleCRC(adu *ADU, response []byte, cnt *byte, section *
 	var crc CRC
 	clen := int(LCRC)
 	if len(response) < (clen + int(LSID+LFNC)) {
-		return fmt.Errorf("Response too short at %v bytes", len(response))
+		return fmt.Errorf("%w: Response too short at %v bytes", ErrFrame, len(response))
 	}
 	crcResponse := binary.BigEndian.Uint16(response[len(response)-clen:])
 	adu.CRC = crcResponse
@@ -323,32 +368,142 @@ func (p *ProtocolBase) handleCRC(adu *ADU, response []byte, cnt *byte, section *
 	if crcResponse == crc.value() {
 		*section = SRTU
 	} else {
-		return fmt.Errorf("Error checking CRC mismatch %v!=%v, %#v\n", adu.CRC, crcResponse, adu)
+		return fmt.Errorf("%w %v!=%v", ErrCRCMismatch, crcResponse, crc.value())
 	}
 	return nil
 }
 
-func (p *ProtocolBase) handleLRC(adu *ADU, response []byte, cnt *byte, section *Section, element *Element) error {
-	*section = SASCII
+func (p *ProtocolBase) handleLRC(adu *ADU, response []byte, cnt *int, section *Section, element *Element) error {
+	/*
+	 * Only protocols that employ this error checking mechanism
+	 * will call on this switch element.
+	 */
+	var lrc LRC
+	elen := int(LEOF)
+	llen := int(LLRC)
+	if len(response) < int(LSOF+LSID+LFNC)+llen+elen {
+		return fmt.Errorf("%w: Response too short at %v bytes", ErrFrame, len(response))
+	}
+	lrcResponse := response[len(response)-elen-llen]
+	adu.LRC = lrcResponse
+	adu.Err = []byte{lrcResponse}
+	lrc.reset().calculate(response[int(LSOF) : len(response)-elen-llen])
+	*section = SFAIL
+	if lrcResponse == lrc.value() {
+		*section = SASCII
+	} else {
+		return fmt.Errorf("%w %v!=%v", ErrLRCMismatch, lrcResponse, lrc.value())
+	}
 	return nil
 }
 
-func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, cnt *byte, start *Section, section *Section, element *Element) error {
+// framed returns the length of the PDU data from cnt up to the error checking of the protocol
+func framed(response []byte, cnt int, start Section) int {
+	dlen := len(response) - cnt
+	switch start {
+	case SRTU:
+		dlen -= int(LCRC)
+	case SASCII:
+		dlen -= int(LLRC + LEOF)
+	}
+	return dlen
+}
+
+func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, request bool, cnt *int, start *Section, section *Section, element *Element) error {
 	var exitElement = false
+	var dlen int
 	*element = EFNCODE
 	// Parse function code, exception code, length and or data from the PDU
 	for {
 		switch *element {
 		case EFNCODE:
+			if *cnt >= len(response) {
+				return fmt.Errorf("%w: Response too short for function code", ErrFrame)
+			}
 			fncode := FnCode(response[*cnt])
 			adu.FnCode = []byte{byte(fncode)}
 			*cnt++
+			if request {
+				/*
+				 * Requests are decoded by the slave according to the function code,
+				 * so the data is everything between the function code and the
+				 * error checking of the protocol.
+				 */
+				dlen = framed(response, *cnt, *start)
+				if dlen < 0 {
+					return fmt.Errorf("%w: Request too short at %v bytes", ErrFrame, len(response))
+				}
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			}
 			if FnCode(adu.FnCode[0]) >= FERR {
 				*element = EEXCODE
 				continue
 			}
 			switch FnCode(adu.FnCode[0]) {
-			case RDCO, RDDI, RDIR, RDHR:
+			case RDCO, RDDI, RDIR, RDHR, RWMR, RDFR, WRFR, GCEL:
+			case MWRR:
+				// Mask write responses echo the address and both masks
+				dlen = 6
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			case FIFO:
+				// FIFO responses carry a two byte count of the FIFO count and values that follow
+				if *cnt+2 > len(response) {
+					return fmt.Errorf("%w: Response too short for FIFO byte count", ErrFrame)
+				}
+				adu.Length = binary.BigEndian.Uint16(response[*cnt:])
+				adu.Data = make([]byte, 0, 2+int(adu.Length))
+				adu.Data = append(adu.Data, response[*cnt:*cnt+2]...)
+				*cnt += 2
+				dlen = int(adu.Length)
+				*element = EDATA
+				continue
+			case MEIT:
+				// Device identification responses list their objects, each with its own length
+				if *cnt >= len(response) || response[*cnt] != MEIDeviceId {
+					return fmt.Errorf("Unsupported MEI type in response")
+				}
+				length, complete := deviceIdLength(response[*cnt:])
+				if !complete {
+					return fmt.Errorf("%w: Response too short for device identification objects", ErrFrame)
+				}
+				dlen = length
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			case RDES:
+				// The exception status is a single byte of outputs
+				dlen = 1
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			case GCEC:
+				// Status followed by the event count
+				dlen = 4
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			case DIAG:
+				// Diagnostic responses echo the sub-function and a length of data only known from the frame
+				dlen = framed(response, *cnt, *start)
+				if dlen < 2 {
+					return fmt.Errorf("%w: Response too short for diagnostic sub-function", ErrFrame)
+				}
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
+			case WRSC, WRSR, WRMC, WRMR:
+				/*
+				 * Write responses echo the address followed by either the value
+				 * written or the quantity of items written, and carry no length.
+				 */
+				dlen = 4
+				adu.Data = make([]byte, 0, dlen)
+				*element = EDATA
+				continue
 			default:
 				return fmt.Errorf("Unsupported function code; %v", FnCode(adu.FnCode[0]))
 			}
@@ -360,6 +515,7 @@ func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, cnt *byte, start *Se
 			case SRTU:
 				*element = ELENGTH
 			case SASCII:
+				*element = ELENGTH
 			default:
 				return fmt.Errorf("Unsupported protocol section code: %v", element)
 			}
@@ -369,27 +525,19 @@ func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, cnt *byte, start *Se
 			 * and has to be dealt with accordingly.
 			 * Extract exception code from response.
 			 */
+			if *cnt >= len(response) {
+				return fmt.Errorf("%w: Response too short for exception code", ErrFrame)
+			}
 			adu.ExceptionCode = ExCode(response[*cnt])
 			adu.Data = make([]byte, 1) // just enough storage for excode
 			adu.Data[0] = byte(adu.ExceptionCode)
 			*cnt++
 
-			// Take action on specific exception codes
-			switch adu.ExceptionCode {
-			case 0:
-			case IllegalFunction:
-			case IllegalDataAddress:
-			case IllegalDataValue:
-			case ServerDeviceFailure:
-			case Acknowledge:
-			case ServerDeviceBusy:
-			case MemoryParityError:
-			case GatewayPathUnavailable:
-			case GatewayTargetDeviceFailedToRespond:
-			default:
-				return fmt.Errorf("Unsupported exception code: %v", adu.ExceptionCode)
-			}
-			// Retrieve exception code description
+			/*
+			 * Retrieve the exception code description. Codes beyond the
+			 * specification, such as those of vendors, are reported to the
+			 * caller as exceptions all the same.
+			 */
 			if _, found := Exception[adu.ExceptionCode]; found {
 				adu.Exception = Exception[adu.ExceptionCode]
 			}
@@ -397,6 +545,9 @@ func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, cnt *byte, start *Se
 			exitElement = true
 		case ELENGTH:
 			// Parse length from response
+			if *cnt >= len(response) {
+				return fmt.Errorf("%w: Response too short for length", ErrFrame)
+			}
 			adu.Length = uint16(response[*cnt])
 			adu.Data = make([]byte, 1) // just enough storage for length
 			adu.Data[0] = byte(adu.Length)
@@ -408,19 +559,27 @@ func (p *ProtocolBase) handlePDU(adu *ADU, response []byte, cnt *byte, start *Se
 			 * response with a degree of uncertainty.
 			 */
 			if adu.Length <= 0 {
-				return fmt.Errorf("Illegal length: %v", adu.Length)
+				return fmt.Errorf("%w: Illegal length: %v", ErrFrame, adu.Length)
 			}
+			dlen = int(adu.Length)
 			*element = EDATA
 		case EDATA:
 			/*
-			 * For the length retrieved in the ELENGTH element iterate
-			 * and extract the raw data portion of the response.
+			 * For the length retrieved in the ELENGTH element, or implied by
+			 * the function code, iterate and extract the raw data portion
+			 * of the response.
 			 */
-			var idx byte
-			for idx = 0; idx < byte(adu.Length); idx++ {
+			if *cnt+dlen > len(response) {
+				return fmt.Errorf("%w: Response too short for %v data bytes", ErrFrame, dlen)
+			}
+			for idx := 0; idx < dlen; idx++ {
 				adu.Data = append(adu.Data, response[*cnt])
 				*cnt++
 			}
+			// Bytes left over before the error checking contradict the length
+			if rest := framed(response, *cnt, *start); rest != 0 {
+				return fmt.Errorf("%w: Response of %v data bytes followed by %v bytes", ErrFrame, dlen, rest)
+			}
 			*section = SDONE
 			exitElement = true
 		default:
@@ -0,0 +1,67 @@
+package modbusd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMaskWriteRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		err      error
+	}{
+		{"an echo", "0000 0000 0008 01 16 0004 00F2 0025", nil},
+		{"an altered echo", "0000 0000 0008 01 16 0004 00F2 0024", ErrResponse},
+		{"an exception", "0000 0000 0003 01 96 02", IllegalDataAddress},
+		{"masks short of the frame", "0000 0000 0007 01 16 0004 00F2 00", ErrFrame},
+		{"masks followed by more bytes", "0000 0000 0009 01 16 0004 00F2 0025 00", ErrFrame},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		c := fakeClient(t, protocol, exchange{"0000 0000 0008 01 16 0004 00F2 0025", test.response})
+		if err := c.MaskWriteRegister(4, 0x00F2, 0x0025); !errors.Is(err, test.err) {
+			t.Errorf("MaskWriteRegister of %s = %v; want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestReadWriteRegisters(t *testing.T) {
+	const request = "0000 0000 0011 01 17 0003 0006 000E 0003 06 00FF 00FF 00FF"
+	tests := []struct {
+		name     string
+		response string
+		values   []uint16
+		err      error
+	}{
+		{
+			"a response",
+			"0000 0000 000F 01 17 0C 00FE 0ACD 0001 0003 000D 00FF",
+			[]uint16{0x00FE, 0x0ACD, 0x0001, 0x0003, 0x000D, 0x00FF},
+			nil,
+		},
+		{"a response of fewer registers", "0000 0000 000B 01 17 08 00FE 0ACD 0001 0003", nil, ErrResponse},
+		{"an exception", "0000 0000 0003 01 97 03", nil, IllegalDataValue},
+		{"a byte count beyond the frame", "0000 0000 0007 01 17 0C 00FE 0ACD", nil, ErrFrame},
+		{"a byte count short of the frame", "0000 0000 0007 01 17 02 00FE 0ACD", nil, ErrFrame},
+	}
+	for _, test := range tests {
+		protocol, _ := NewModbusTCP(1)
+		c := fakeClient(t, protocol, exchange{request, test.response})
+		values, err := c.ReadWriteRegisters(3, 6, 0x0E, []uint16{0x00FF, 0x00FF, 0x00FF})
+		if !errors.Is(err, test.err) || !reflect.DeepEqual(values, test.values) {
+			t.Errorf("ReadWriteRegisters of %s = %#04x, %v; want %#04x, %v", test.name, values, err, test.values, test.err)
+		}
+	}
+
+	// Requests beyond the limits of the function are refused without a transaction
+	protocol, _ := NewModbusTCP(1)
+	c := fakeClient(t, protocol)
+	if _, err := c.ReadWriteRegisters(0, 0, 0, []uint16{1}); err == nil {
+		t.Error("ReadWriteRegisters of no registers")
+	}
+	if _, err := c.ReadWriteRegisters(0, 1, 0, make([]uint16, MaxReadWriteRegisters+1)); err == nil {
+		t.Error("ReadWriteRegisters of too many registers")
+	}
+}
@@ -1,11 +1,30 @@
 package modbusd
 
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
 const (
 	LRTU Length = 1
 )
 
+// Maximum size of an RTU frame on a serial line
+const MaxRTU int = 256
+
+// Idle time that ends an RTU frame carried over TCP, which allows for segments delayed by the network
+const tcpSilence time.Duration = 50 * time.Millisecond
+
 type RTU struct {
 	ProtocolBase
+	/*
+	 * Silence is the idle time that marks the end of a frame. When zero it
+	 * is derived from the line settings of a serial transport, while over
+	 * TCP a short fixed gap applies.
+	 */
+	Silence time.Duration
 }
 
 // Creates an instance of the RTU protocol class
@@ -15,7 +34,7 @@ func NewRTU(slaveid byte) (*RTU, error) {
 	return rtu, nil
 }
 
-//Encode builds the RTU modbus protocol header and add error checking
+// Encode builds the RTU modbus protocol header and add error checking
 func (r *RTU) Encode(pdu *PDU) (*ADU, error) {
 	// Construct the Application Data Unit for the Modbus TCP protocol
 	adu, err := NewADU(pdu)
@@ -39,3 +58,214 @@ func (r *RTU) Decode(response []byte) (*ADU, error) {
 	}
 	return adu, nil
 }
+
+/*
+ * Expected returns the length of the RTU response frame at the start of
+ * buffer as determined by its function code. A length of zero means that
+ * more bytes are required to tell, and false is returned for function codes
+ * whose length can only be determined by the silence following the frame.
+ */
+func (r *RTU) Expected(buffer []byte) (int, bool) {
+	hlen := int(LSID + LFNC)
+	if len(buffer) < hlen {
+		return 0, true
+	}
+	fncode := FnCode(buffer[1])
+	if fncode >= FERR {
+		// Slave Id, function code, exception code and CRC
+		return hlen + int(LEXC+LCRC), true
+	}
+	switch fncode {
+	case RDCO, RDDI, RDHR, RDIR, RWMR, RDFR, WRFR, GCEL, RSID:
+		// Slave Id, function code, byte count, data and CRC
+		if len(buffer) < hlen+1 {
+			return 0, true
+		}
+		return hlen + 1 + int(buffer[hlen]) + int(LCRC), true
+	case WRSC, WRSR, WRMC, WRMR:
+		// Address followed by the value or quantity written
+		return hlen + 4 + int(LCRC), true
+	case MWRR:
+		// Address followed by the AND and OR masks
+		return hlen + 6 + int(LCRC), true
+	case RDES:
+		// Single byte of exception status
+		return hlen + 1 + int(LCRC), true
+	case GCEC:
+		// Status followed by the event count
+		return hlen + 4 + int(LCRC), true
+	case DIAG:
+		// Sub-function followed by a single word, except for the echo of query data of any length
+		if len(buffer) < hlen+2 {
+			return 0, true
+		}
+		if binary.BigEndian.Uint16(buffer[hlen:]) == ReturnQueryData {
+			return 0, false
+		}
+		return hlen + 4 + int(LCRC), true
+	case MEIT:
+		// Device identification header and objects, each with its own length
+		if len(buffer) < hlen+1 {
+			return 0, true
+		}
+		if buffer[hlen] != MEIDeviceId {
+			return 0, false
+		}
+		length, complete := deviceIdLength(buffer[hlen:])
+		if !complete {
+			return 0, true
+		}
+		return hlen + length + int(LCRC), true
+	case FIFO:
+		// Two byte byte count, FIFO count, queued values and CRC
+		if len(buffer) < hlen+2 {
+			return 0, true
+		}
+		return hlen + 2 + int(binary.BigEndian.Uint16(buffer[hlen:])) + int(LCRC), true
+	default:
+		return 0, false
+	}
+}
+
+/*
+ * ExpectedRequest returns the length of the RTU request frame at the start
+ * of buffer as determined by its function code, as received by a slave.
+ */
+func (r *RTU) ExpectedRequest(buffer []byte) (int, bool) {
+	hlen := int(LSID + LFNC)
+	if len(buffer) < hlen {
+		return 0, true
+	}
+	// Length of the request up to and including its byte count, if any
+	counted := func(offset int) (int, bool) {
+		if len(buffer) < offset+1 {
+			return 0, true
+		}
+		return offset + 1 + int(buffer[offset]) + int(LCRC), true
+	}
+	switch FnCode(buffer[1]) {
+	case RDCO, RDDI, RDHR, RDIR, WRSC, WRSR, DIAG:
+		return hlen + 4 + int(LCRC), true
+	case RDES, GCEC, GCEL, RSID:
+		return hlen + int(LCRC), true
+	case WRMC, WRMR:
+		return counted(hlen + 4)
+	case RDFR, WRFR:
+		return counted(hlen)
+	case MWRR:
+		return hlen + 6 + int(LCRC), true
+	case RWMR:
+		return counted(hlen + 8)
+	case FIFO:
+		return hlen + 2 + int(LCRC), true
+	case MEIT:
+		return hlen + 3 + int(LCRC), true
+	default:
+		return 0, false
+	}
+}
+
+// silence returns the idle time that marks the end of a frame on the transport
+func (r *RTU) silence(t Transport) time.Duration {
+	if r.Silence > 0 {
+		return r.Silence
+	}
+	switch transport := t.(type) {
+	case *Serial:
+		return transport.Silence()
+	case *TCP:
+		// Network delays dwarf character times, but a frame is rarely split for longer
+		return tcpSilence
+	default:
+		return serialTimeout
+	}
+}
+
+/*
+ * Assemble collects a complete RTU frame in the transport buffer. The first
+ * character is awaited within the transport timeout, after which the frame
+ * is complete once the length expected from the function code has been
+ * received or once the line has been silent for 3.5 character times.
+ */
+func (r *RTU) Assemble(t Transport) (int, error) {
+	return r.AssembleContext(context.Background(), t)
+}
+
+// AssembleContext collects a complete RTU frame, giving up once the context is done
+func (r *RTU) AssembleContext(ctx context.Context, t Transport) (int, error) {
+	return r.assemble(ctx, t, r.Expected)
+}
+
+// AssembleRequest collects a complete RTU request frame, returning a zero length if done is signalled
+func (r *RTU) AssembleRequest(t Transport, done chan bool) (int, error) {
+	ctx, cancel := doneContext(done)
+	defer cancel()
+	length, err := r.assemble(ctx, t, r.ExpectedRequest)
+	if err != nil && ctx.Err() != nil {
+		// Being aborted by done is not an error
+		return 0, nil
+	}
+	return length, err
+}
+
+func (r *RTU) assemble(ctx context.Context, t Transport, expect func([]byte) (int, bool)) (int, error) {
+	receiver, ok := t.(Receiver)
+	if !ok {
+		return 0, fmt.Errorf("Transport unable to assemble RTU frames: %T", t)
+	}
+	if !streamed(t) {
+		return 0, fmt.Errorf("RTU frames over TCP require stream framing")
+	}
+	if err := t.ListenContext(ctx); err != nil {
+		return 0, err
+	}
+	silence := r.silence(t)
+	for {
+		t.Lock()
+		buffer := t.Buffer()
+		expected, known := expect(buffer)
+		t.Unlock()
+		switch {
+		case len(buffer) == 0:
+			// Listening was aborted before any character arrived
+			return 0, nil
+		case len(buffer) > MaxRTU:
+			return 0, fmt.Errorf("%w: Frame exceeds %v bytes", ErrFrame, MaxRTU)
+		case known && expected > 0 && len(buffer) >= expected:
+			return expected, nil
+		}
+		if err := receiver.ReceiveContext(ctx, silence); err != nil {
+			if ctx.Err() != nil || !isTimeout(err) {
+				return 0, err
+			}
+			// The line has been silent long enough to mark the end of the frame
+			if known {
+				return 0, fmt.Errorf("%w: Frame truncated at %v bytes", ErrFrame, len(buffer))
+			}
+			return len(buffer), nil
+		}
+	}
+}
+
+// Reply builds the RTU response to a request, addressed from the slave id of the request
+func (r *RTU) Reply(request *ADU, pdu *PDU) (*ADU, error) {
+	adu, err := NewADU(pdu)
+	if err != nil {
+		return nil, err
+	}
+	adu.SlaveId = request.SlaveId
+	adu.Hdr = []byte{adu.SlaveId}
+	if err = adu.ErrorCRC(); err != nil {
+		return nil, err
+	}
+	return adu, nil
+}
+
+// DecodeRequest calls on the ProtocolBase function RecoverRequest to decode RTU requests received by a slave
+func (r *RTU) DecodeRequest(request []byte) (*ADU, error) {
+	adu, err := r.RecoverRequest(request, SRTU)
+	if err != nil {
+		return nil, err
+	}
+	return adu, nil
+}
@@ -0,0 +1,52 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package modbusd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSerialRTURead(t *testing.T) {
+	master, name := openPty(t)
+	// The silence ending a frame at a low baud rate allows for delays in writing the fragments
+	serial, err := NewSerial(name, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial.Parity = EvenParity
+	rtu, _ := NewRTU(1)
+	c := &Client{Protocol: rtu, Transport: serial}
+	defer c.Close()
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	expected := rtuFrame(t, 1, RDHR, []byte{0, 1, 0, 2})
+	response := rtuFrame(t, 1, RDHR, []byte{4, 0x12, 0x34, 0xAB, 0xCD})
+	errc := make(chan error, 1)
+	go func() {
+		if request := readPty(master, time.Second); !bytes.Equal(request, expected) {
+			errc <- fmt.Errorf("Request %x!=%x", request, expected)
+			return
+		}
+		// The response is split to exercise the assembly of the frame by its expected length
+		master.Write(response[:3])
+		time.Sleep(time.Millisecond)
+		_, err := master.Write(response[3:])
+		errc <- err
+	}()
+	values, err := c.ReadHoldingRegisters(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != 0x1234 || values[1] != 0xABCD {
+		t.Fatalf("Unexpected values: %v", values)
+	}
+}
@@ -1,42 +1,230 @@
 package modbusd
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const serialTimeout time.Duration = 1 * time.Second
+
+type Parity byte
+
+// Parity options of a serial line
+const (
+	NoParity   Parity = 'N'
+	EvenParity Parity = 'E'
+	OddParity  Parity = 'O'
+)
+
+type Direction int
+
+/*
+ * RS-485 is half duplex and the transceiver has to be switched to transmit
+ * for the duration of a request. Depending on the hardware this is done by
+ * the transceiver itself, by the kernel driver or by the transport toggling
+ * the RTS line around each transmission.
+ */
+const (
+	DirectionNone   Direction = 0 // No direction control (RS-232 or auto direction transceiver)
+	DirectionKernel Direction = 1 // Kernel driver toggles RTS in RS-485 mode
+	DirectionRTS    Direction = 2 // Transport raises RTS while transmitting
+)
+
 type Serial struct {
 	TransportBase
-	COM  string
-	Baud uint32
+	COM      string
+	Baud     uint32
+	DataBits byte
+	Parity   Parity
+	StopBits byte
+	Timeout  time.Duration
+
+	Direction     Direction
+	RTSBeforeSend time.Duration // Delay between raising RTS and transmitting
+	RTSAfterSend  time.Duration // Delay between transmission and dropping RTS
+
+	port *os.File
 }
 
-// NewSerial creates an instance of the Serial class
+// NewSerial creates an instance of the Serial class with 8 data bits, no parity and 1 stop bit
 func NewSerial(com string, baud uint32) (*Serial, error) {
+	if com == "" {
+		return nil, fmt.Errorf("Illegal serial device: %s", com)
+	}
 	return &Serial{
-		COM:  com,
-		Baud: baud,
+		COM:      com,
+		Baud:     baud,
+		DataBits: 8,
+		Parity:   NoParity,
+		StopBits: 1,
+		Timeout:  serialTimeout,
 	}, nil
 }
 
 // Connect establishes a connection to a serial port device
 func (s *Serial) Connect() error {
+	return s.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes a connection to a serial port device unless the context is done
+func (s *Serial) ConnectContext(ctx context.Context) error {
+	if s.port != nil {
+		// The serial line remains open between requests
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = serialTimeout
+	}
+	switch s.Parity {
+	case NoParity, EvenParity, OddParity:
+	default:
+		return fmt.Errorf("Illegal parity: %c", s.Parity)
+	}
+	if s.DataBits < 5 || s.DataBits > 8 {
+		return fmt.Errorf("Illegal number of data bits: %v", s.DataBits)
+	}
+	if s.StopBits < 1 || s.StopBits > 2 {
+		return fmt.Errorf("Illegal number of stop bits: %v", s.StopBits)
+	}
+	if err := s.open(); err != nil {
+		return fmt.Errorf("Could not open serial device %s: %s", s.COM, err)
+	}
 	return nil
 }
 
 // Send implements the transmission on the transport
-func (s *Serial) Send() error {
-	return nil
+func (s *Serial) Send(adu *ADU) error {
+	return s.SendContext(context.Background(), adu)
 }
 
-// Listen implements the recevier on the transport
-func (s *Serial) Listen(done chan bool) error {
-	for {
-		select {
-		case <-done:
-			break
+// SendContext transmits on the transport, aborting the write once the context is done
+func (s *Serial) SendContext(ctx context.Context, adu *ADU) error {
+	var err error
+	if s.port == nil {
+		if err = s.ConnectContext(ctx); err != nil {
+			return err
 		}
 	}
 
+	var aduBytes []byte
+	if aduBytes, err = adu.Bytes(); err != nil {
+		return err
+	}
+	if err = s.port.SetWriteDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return err
+	}
+	if s.Direction == DirectionRTS {
+		if err = s.rts(true); err != nil {
+			return fmt.Errorf("Unable to raise RTS: %s", err)
+		}
+		time.Sleep(s.RTSBeforeSend)
+	}
+	stop := interrupt(ctx, s.port.SetWriteDeadline)
+	_, err = s.port.Write(aduBytes)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if s.Direction == DirectionRTS {
+		/*
+		 * Release the bus only once the last character has left the
+		 * UART, otherwise the tail of the request is truncated.
+		 */
+		if derr := s.drain(); derr != nil && err == nil {
+			err = derr
+		}
+		time.Sleep(s.RTSAfterSend)
+		if rerr := s.rts(false); rerr != nil && err == nil {
+			err = fmt.Errorf("Unable to drop RTS: %s", rerr)
+		}
+	}
+	return err
+}
+
+// Listen implements the recevier on the transport, returning without data once done is signalled
+func (s *Serial) Listen(done chan bool) error {
+	ctx, cancel := doneContext(done)
+	defer cancel()
+	if err := s.ListenContext(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	// Being aborted by done is not an error
 	return nil
 }
 
-// Close closes and cleans up after the connection
-func (t *Serial) Close() error {
+// ListenContext waits up to the transport timeout for data, or until the context is done
+func (s *Serial) ListenContext(ctx context.Context) error {
+	return s.ReceiveContext(ctx, s.Timeout)
+}
+
+// Receive waits up to the given period for data on the transport
+func (s *Serial) Receive(wait time.Duration) error {
+	return s.ReceiveContext(context.Background(), wait)
+}
+
+// ReceiveContext waits up to the given period for data on the transport, or until the context is done
+func (s *Serial) ReceiveContext(ctx context.Context, wait time.Duration) error {
+	var err error
+	var cnt int
+	if s.port == nil {
+		return fmt.Errorf("Serial device not open: %s", s.COM)
+	}
+	if err = s.port.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return err
+	}
+	response := make([]byte, 256)
+	stop := interrupt(ctx, s.port.SetReadDeadline)
+	cnt, err = s.port.Read(response)
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if cnt > 0 {
+		// Ensure exclusive access to the resource
+		s.M.Lock()
+		s.Response.Write(response[0:cnt])
+		s.M.Unlock()
+	}
 	return nil
 }
+
+// CharTime is the time taken to transmit a single character at the line settings
+func (s *Serial) CharTime() time.Duration {
+	bits := 1 + int(s.DataBits) + int(s.StopBits)
+	if s.Parity != NoParity {
+		bits++
+	}
+	if s.Baud == 0 {
+		return 0
+	}
+	return time.Duration(bits) * time.Second / time.Duration(s.Baud)
+}
+
+/*
+ * Silence is the minimum idle time of 3.5 characters that separates RTU frames.
+ * Above 19200 baud the specification fixes the silence at 1.75ms.
+ */
+func (s *Serial) Silence() time.Duration {
+	if s.Baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return s.CharTime() * 7 / 2
+}
+
+// Close closes and cleans up after the connection
+func (s *Serial) Close() error {
+	if s.port == nil {
+		return nil
+	}
+	err := s.port.Close()
+	s.port = nil
+	return err
+}
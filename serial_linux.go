@@ -0,0 +1,178 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package modbusd
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/*
+ * Terminal control values of the generic Linux ABI that are not
+ * exported by the syscall package. Architectures with their own ioctl
+ * numbers or termios layout, such as mips and powerpc, are left to
+ * serial_other.go.
+ */
+const (
+	cbaud      uint32  = 0x0000100F
+	crtscts    uint32  = 0x80000000
+	tcsbrk     uintptr = 0x5409
+	tcflsh     uintptr = 0x540B
+	tiocsrs485 uintptr = 0x542F
+
+	serRS485Enabled   uint32 = 1 << 0
+	serRS485RTSOnSend uint32 = 1 << 1
+)
+
+// Kernel representation of struct serial_rs485
+type rs485 struct {
+	Flags              uint32
+	DelayRTSBeforeSend uint32
+	DelayRTSAfterSend  uint32
+	Padding            [5]uint32
+}
+
+// Supported baud rates mapped to their termios speed
+var bauds = map[uint32]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// control runs fn on the raw descriptor without forcing the port into blocking mode
+func (s *Serial) control(fn func(fd uintptr) error) error {
+	raw, err := s.port.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err = raw.Control(func(fd uintptr) {
+		ferr = fn(fd)
+	}); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// open opens the serial device in raw mode and applies the line settings
+func (s *Serial) open() error {
+	speed, found := bauds[s.Baud]
+	if !found {
+		return fmt.Errorf("Unsupported baud rate: %v", s.Baud)
+	}
+	/*
+	 * The device is opened non blocking so that reads and writes are
+	 * serviced by the runtime poller and honour deadlines.
+	 */
+	port, err := os.OpenFile(s.COM, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	s.port = port
+	if err = s.control(func(fd uintptr) error {
+		return s.configure(fd, speed)
+	}); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// configure applies the equivalent of cfmakeraw followed by the line settings
+func (s *Serial) configure(fd uintptr, speed uint32) error {
+	var tio syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&tio))); err != nil {
+		return fmt.Errorf("Unable to get terminal attributes: %s", err)
+	}
+	tio.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF | syscall.INPCK
+	tio.Oflag &^= syscall.OPOST
+	tio.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	tio.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | crtscts | cbaud
+	tio.Cflag |= syscall.CREAD | syscall.CLOCAL | speed
+	tio.Ispeed = speed
+	tio.Ospeed = speed
+
+	switch s.DataBits {
+	case 5:
+		tio.Cflag |= syscall.CS5
+	case 6:
+		tio.Cflag |= syscall.CS6
+	case 7:
+		tio.Cflag |= syscall.CS7
+	default:
+		tio.Cflag |= syscall.CS8
+	}
+	switch s.Parity {
+	case EvenParity:
+		tio.Cflag |= syscall.PARENB
+		tio.Iflag |= syscall.INPCK
+	case OddParity:
+		tio.Cflag |= syscall.PARENB | syscall.PARODD
+		tio.Iflag |= syscall.INPCK
+	}
+	if s.StopBits == 2 {
+		tio.Cflag |= syscall.CSTOPB
+	}
+	/*
+	 * Reads wait for at least one character, leaving timing to read deadlines.
+	 * A zero minimum would report an idle line as end of file.
+	 */
+	tio.Cc[syscall.VMIN] = 1
+	tio.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&tio))); err != nil {
+		return fmt.Errorf("Unable to set terminal attributes: %s", err)
+	}
+
+	if s.Direction == DirectionKernel {
+		conf := rs485{
+			Flags:              serRS485Enabled | serRS485RTSOnSend,
+			DelayRTSBeforeSend: uint32(s.RTSBeforeSend.Milliseconds()),
+			DelayRTSAfterSend:  uint32(s.RTSAfterSend.Milliseconds()),
+		}
+		if err := ioctl(fd, tiocsrs485, uintptr(unsafe.Pointer(&conf))); err != nil {
+			return fmt.Errorf("Unable to enable RS-485 mode: %s", err)
+		}
+	}
+	// Discard anything received before the line was configured
+	return ioctl(fd, tcflsh, uintptr(syscall.TCIOFLUSH))
+}
+
+// rts raises or drops the RTS modem control line
+func (s *Serial) rts(on bool) error {
+	return s.control(func(fd uintptr) error {
+		var request uintptr = syscall.TIOCMBIC
+		if on {
+			request = syscall.TIOCMBIS
+		}
+		var bits int32 = syscall.TIOCM_RTS
+		return ioctl(fd, request, uintptr(unsafe.Pointer(&bits)))
+	})
+}
+
+// drain blocks until all written characters have been transmitted
+func (s *Serial) drain() error {
+	return s.control(func(fd uintptr) error {
+		return ioctl(fd, tcsbrk, 1)
+	})
+}
@@ -0,0 +1,130 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package modbusd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens the master of a pseudo-terminal pair and returns it with the name of the slave device
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Skipf("Pseudo-terminals unavailable: %s", err)
+	}
+	raw, err := master.SyscallConn()
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	var unlock int32
+	var number uint32
+	var ferr error
+	raw.Control(func(fd uintptr) {
+		if ferr = ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); ferr == nil {
+			ferr = ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number)))
+		}
+	})
+	if ferr != nil {
+		master.Close()
+		t.Fatalf("Unable to unlock pseudo-terminal: %s", ferr)
+	}
+	t.Cleanup(func() { master.Close() })
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+// rtuFrame encodes an RTU frame for the slave id
+func rtuFrame(t *testing.T, slaveid byte, fncode FnCode, data []byte) []byte {
+	rtu, _ := NewRTU(slaveid)
+	pdu, err := NewPDU(fncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu.Data = data
+	adu, err := rtu.Encode(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := adu.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+// readPty reads whatever the other end of the pseudo-terminal writes within the wait
+func readPty(master *os.File, wait time.Duration) []byte {
+	buffer := make([]byte, 256)
+	master.SetReadDeadline(time.Now().Add(wait))
+	var frame []byte
+	for {
+		n, err := master.Read(buffer)
+		frame = append(frame, buffer[:n]...)
+		if err != nil {
+			return frame
+		}
+		// The remainder of a frame follows within a few character times
+		master.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	}
+}
+
+func TestSerialTransport(t *testing.T) {
+	master, name := openPty(t)
+	serial, err := NewSerial(name, 19200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial.Parity = EvenParity
+	serial.Timeout = 100 * time.Millisecond
+	if err = serial.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer serial.Close()
+
+	rtu, _ := NewRTU(1)
+	pdu, _ := NewPDU(RDHR)
+	pdu.Data = []byte{0, 1, 0, 2}
+	adu, err := rtu.Encode(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = serial.Send(adu); err != nil {
+		t.Fatal(err)
+	}
+	expected := rtuFrame(t, 1, RDHR, []byte{0, 1, 0, 2})
+	if request := readPty(master, time.Second); !bytes.Equal(request, expected) {
+		t.Fatalf("Request %x!=%x", request, expected)
+	}
+
+	// Characters received are appended to the response buffer
+	response := rtuFrame(t, 1, RDHR, []byte{4, 0x12, 0x34, 0xAB, 0xCD})
+	master.Write(response)
+	for len(serial.Buffer()) < len(response) {
+		if err = serial.Listen(nil); err != nil {
+			t.Fatalf("Listen: %s", err)
+		}
+	}
+	if !bytes.Equal(serial.Buffer(), response) {
+		t.Fatalf("Response %x!=%x", serial.Buffer(), response)
+	}
+	serial.Flush()
+
+	// A silent line times out, unless the caller gives up first
+	if err = serial.Listen(nil); err == nil {
+		t.Error("Listen on a silent line")
+	}
+	done := make(chan bool)
+	time.AfterFunc(10*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	if err = serial.Listen(done); err != nil || time.Since(start) >= serial.Timeout {
+		t.Errorf("Aborted Listen = %v after %v", err, time.Since(start))
+	}
+}
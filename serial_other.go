@@ -0,0 +1,22 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build !linux !386,!amd64,!arm,!arm64,!loong64,!riscv64,!s390x
+
+package modbusd
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// open is only implemented for Linux termios on architectures with the generic ioctl numbers
+func (s *Serial) open() error {
+	return fmt.Errorf("Serial transport unsupported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func (s *Serial) rts(on bool) error {
+	return fmt.Errorf("Serial transport unsupported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func (s *Serial) drain() error {
+	return fmt.Errorf("Serial transport unsupported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,351 @@
+package modbusd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const serverTimeout time.Duration = 60 * time.Second
+
+// Server is a Modbus TCP slave serving a DataModel to any number of masters
+type Server struct {
+	Model   DataModel
+	Timeout time.Duration // Idle time after which a connection is closed
+
+	protocol *ModbusTCP
+	handler  func(request *ADU) *PDU
+	m        sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// NewServer creates an instance of the Server class
+func NewServer(model DataModel) (*Server, error) {
+	if model == nil {
+		return nil, fmt.Errorf("Illegal data model")
+	}
+	s, err := newServer(nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Model = model
+	s.handler = func(request *ADU) *PDU {
+		return respond(s.Model, request)
+	}
+	return s, nil
+}
+
+// newServer creates a Server answering requests with the handler, which returns nil for requests without a reply
+func newServer(handler func(request *ADU) *PDU) (*Server, error) {
+	protocol, err := NewModbusTCP(0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create protocol: %s", err)
+	}
+	return &Server{
+		Timeout:  serverTimeout,
+		protocol: protocol,
+		handler:  handler,
+		conns:    make(map[net.Conn]bool),
+	}, nil
+}
+
+// ListenAndServe listens on the TCP address and serves masters until the server is closed
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %s", address, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each on its own goroutine
+func (s *Server) Serve(listener net.Listener) error {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		listener.Close()
+		return fmt.Errorf("Server closed")
+	}
+	s.listener = listener
+	s.m.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.m.Lock()
+			closed := s.closed
+			s.m.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.m.Lock()
+		if s.closed {
+			// Closed while accepting, after the connections were closed
+			s.m.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = true
+		s.m.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// Close stops listening and closes all connections
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// handle serves the requests of a single master until it disconnects or idles
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.m.Lock()
+		delete(s.conns, conn)
+		s.m.Unlock()
+		conn.Close()
+	}()
+	for {
+		if s.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(s.Timeout))
+		}
+		frame, err := readMBAP(conn)
+		if err != nil {
+			return
+		}
+		request, err := s.protocol.DecodeRequest(frame)
+		if err != nil {
+			// Without a valid header there is no transaction to answer
+			return
+		}
+		pdu := s.handler(request)
+		if pdu == nil {
+			continue
+		}
+		response, err := s.protocol.Reply(request, pdu)
+		if err != nil {
+			return
+		}
+		responseBytes, err := response.Bytes()
+		if err != nil {
+			return
+		}
+		if _, err = conn.Write(responseBytes); err != nil {
+			return
+		}
+	}
+}
+
+/*
+ * readMBAP reads a single frame, using the MBAP length to find its end. On
+ * failure the bytes read so far are returned along with the error.
+ */
+func readMBAP(r io.Reader) ([]byte, error) {
+	frame := make([]byte, LMBAP, int(LMBAP)+int(MaxMBAPLength))
+	if n, err := io.ReadFull(r, frame); err != nil {
+		return frame[:n], err
+	}
+	length := binary.BigEndian.Uint16(frame[(LTID + LPID):])
+	if length < uint16(LSID+LFNC) || length > MaxMBAPLength {
+		return frame, fmt.Errorf("%w: Illegal MBAP length: %v", ErrFrame, length)
+	}
+	// The unit id is part of the header already read
+	frame = frame[:int(LMBAP)+int(length)-int(LSID)]
+	if n, err := io.ReadFull(r, frame[LMBAP:]); err != nil {
+		return frame[:int(LMBAP)+n], err
+	}
+	return frame, nil
+}
+
+// respond executes a request on the data model and builds the response or exception PDU
+func respond(model DataModel, request *ADU) *PDU {
+	fncode := FnCode(request.FnCode[0])
+	data, err := serve(model, fncode, request.Data)
+	if err != nil {
+		excode, ok := err.(ExCode)
+		if !ok {
+			excode = ServerDeviceFailure
+		}
+		return exception(fncode, excode)
+	}
+	return &PDU{
+		FnCode: []byte{byte(fncode)},
+		Data:   data,
+	}
+}
+
+// exception builds the exception response PDU to a function code
+func exception(fncode FnCode, excode ExCode) *PDU {
+	return &PDU{
+		FnCode: []byte{byte(fncode | FERR)},
+		Data:   []byte{byte(excode)},
+	}
+}
+
+// serve decodes the request data of a function code, executes it and encodes the response data
+func serve(model DataModel, fncode FnCode, data []byte) ([]byte, error) {
+	switch fncode {
+	case RDCO, RDDI:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		quantity := binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > MaxReadBits {
+			return nil, IllegalDataValue
+		}
+		var values []bool
+		var err error
+		if fncode == RDCO {
+			values, err = model.ReadCoils(address, quantity)
+		} else {
+			values, err = model.ReadDiscreteInputs(address, quantity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		packed := packBits(values)
+		return append([]byte{byte(len(packed))}, packed...), nil
+	case RDHR, RDIR:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		quantity := binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > MaxReadRegisters {
+			return nil, IllegalDataValue
+		}
+		var values []uint16
+		var err error
+		if fncode == RDHR {
+			values, err = model.ReadHoldingRegisters(address, quantity)
+		} else {
+			values, err = model.ReadInputRegisters(address, quantity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return registerBytes(values), nil
+	case WRSC:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		value := binary.BigEndian.Uint16(data[2:])
+		if value != CoilOn && value != CoilOff {
+			return nil, IllegalDataValue
+		}
+		if err := model.WriteCoils(address, []bool{value == CoilOn}); err != nil {
+			return nil, err
+		}
+		// The response echoes the request
+		return data, nil
+	case WRSR:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		value := binary.BigEndian.Uint16(data[2:])
+		if err := model.WriteHoldingRegisters(address, []uint16{value}); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case WRMC:
+		if len(data) < 5 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		quantity := binary.BigEndian.Uint16(data[2:])
+		count := int(data[4])
+		if quantity < 1 || quantity > MaxWriteCoils || count != int(quantity+7)/8 || len(data) != 5+count {
+			return nil, IllegalDataValue
+		}
+		if err := model.WriteCoils(address, unpackBits(data[5:], int(quantity))); err != nil {
+			return nil, err
+		}
+		return data[:4], nil
+	case WRMR:
+		if len(data) < 5 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		quantity := binary.BigEndian.Uint16(data[2:])
+		count := int(data[4])
+		if quantity < 1 || quantity > MaxWriteRegisters || count != int(quantity)*2 || len(data) != 5+count {
+			return nil, IllegalDataValue
+		}
+		if err := model.WriteHoldingRegisters(address, registerValues(data[5:])); err != nil {
+			return nil, err
+		}
+		return data[:4], nil
+	case MWRR:
+		if len(data) != 6 {
+			return nil, IllegalDataValue
+		}
+		address := binary.BigEndian.Uint16(data)
+		and := binary.BigEndian.Uint16(data[2:])
+		or := binary.BigEndian.Uint16(data[4:])
+		if err := model.MaskWriteRegister(address, and, or); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case RWMR:
+		if len(data) < 9 {
+			return nil, IllegalDataValue
+		}
+		raddress := binary.BigEndian.Uint16(data)
+		rquantity := binary.BigEndian.Uint16(data[2:])
+		waddress := binary.BigEndian.Uint16(data[4:])
+		wquantity := binary.BigEndian.Uint16(data[6:])
+		count := int(data[8])
+		if rquantity < 1 || rquantity > MaxReadRegisters ||
+			wquantity < 1 || wquantity > MaxReadWriteRegisters ||
+			count != int(wquantity)*2 || len(data) != 9+count {
+			return nil, IllegalDataValue
+		}
+		// The write is performed before the read
+		if err := model.WriteHoldingRegisters(waddress, registerValues(data[9:])); err != nil {
+			return nil, err
+		}
+		values, err := model.ReadHoldingRegisters(raddress, rquantity)
+		if err != nil {
+			return nil, err
+		}
+		return registerBytes(values), nil
+	default:
+		return nil, IllegalFunction
+	}
+}
+
+// registerBytes encodes registers big endian, preceded by the byte count
+func registerBytes(values []uint16) []byte {
+	data := make([]byte, 1+2*len(values))
+	data[0] = byte(2 * len(values))
+	for idx, value := range values {
+		binary.BigEndian.PutUint16(data[1+2*idx:], value)
+	}
+	return data
+}
+
+// registerValues decodes big endian registers
+func registerValues(data []byte) []uint16 {
+	values := make([]uint16, len(data)/2)
+	for idx := range values {
+		values[idx] = binary.BigEndian.Uint16(data[2*idx:])
+	}
+	return values
+}
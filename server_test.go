@@ -0,0 +1,154 @@
+package modbusd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// loopbackClient serves the model on a loopback listener and connects a ModbusTCP client to it
+func loopbackClient(t *testing.T, model DataModel) (*Server, *Client) {
+	server, err := NewServer(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	address := listener.Addr().(*net.TCPAddr)
+	transport, _ := NewTCP(address.IP.String(), uint16(address.Port), time.Second)
+	protocol, _ := NewModbusTCP(1)
+	c := &Client{Protocol: protocol, Transport: transport}
+	t.Cleanup(c.Close)
+	return server, c
+}
+
+func TestServerBits(t *testing.T) {
+	memory := NewMemory(20, 10, 10, 10)
+	memory.DiscreteInputs[3] = true
+	memory.DiscreteInputs[9] = true
+	_, c := loopbackClient(t, memory)
+
+	if err := c.WriteCoils(1, []bool{true, false, true}); err != nil {
+		t.Fatalf("WriteCoils: %s", err)
+	}
+	if err := c.WriteCoil(10, true); err != nil {
+		t.Fatalf("WriteCoil: %s", err)
+	}
+	if err := c.WriteCoil(1, false); err != nil {
+		t.Fatalf("WriteCoil: %s", err)
+	}
+	coils, err := c.ReadCoils(0, 11)
+	if err != nil {
+		t.Fatalf("ReadCoils: %s", err)
+	}
+	for idx, on := range coils {
+		if on != (idx == 3 || idx == 10) {
+			t.Errorf("Coil %v = %v", idx, on)
+		}
+	}
+	inputs, err := c.ReadDiscreteInputs(3, 7)
+	if err != nil || len(inputs) != 7 || !inputs[0] || inputs[1] || !inputs[6] {
+		t.Errorf("ReadDiscreteInputs(3, 7) = %v, %v", inputs, err)
+	}
+}
+
+func TestServerRegisters(t *testing.T) {
+	memory := NewMemory(10, 10, 200, 10)
+	memory.InputRegisters[2] = 77
+	_, c := loopbackClient(t, memory)
+
+	values := make([]uint16, MaxWriteRegisters)
+	for idx := range values {
+		values[idx] = uint16(3 * idx)
+	}
+	if err := c.WriteRegisters(50, values); err != nil {
+		t.Fatalf("WriteRegisters: %s", err)
+	}
+	if err := c.WriteRegister(0, 9); err != nil {
+		t.Fatalf("WriteRegister: %s", err)
+	}
+	registers, err := c.ReadHoldingRegisters(50, MaxReadRegisters)
+	if err != nil || registers[0] != 0 || registers[122] != 366 || registers[124] != 0 {
+		t.Errorf("ReadHoldingRegisters(50, %v) = %v, %v", MaxReadRegisters, registers, err)
+	}
+	if registers, err = c.ReadHoldingRegisters(0, 1); err != nil || registers[0] != 9 {
+		t.Errorf("ReadHoldingRegisters(0, 1) = %v, %v", registers, err)
+	}
+	if registers, err = c.ReadInputRegisters(2, 1); err != nil || registers[0] != 77 {
+		t.Errorf("ReadInputRegisters(2, 1) = %v, %v", registers, err)
+	}
+
+	// The example of the specification: (0x12 AND 0xF2) OR (0x25 AND NOT 0xF2) = 0x17
+	memory.HoldingRegisters[4] = 0x0012
+	if err = c.MaskWriteRegister(4, 0x00F2, 0x0025); err != nil {
+		t.Fatalf("MaskWriteRegister: %s", err)
+	}
+	if memory.HoldingRegisters[4] != 0x0017 {
+		t.Errorf("MaskWriteRegister = %#04x; want 0x0017", memory.HoldingRegisters[4])
+	}
+	// The write is performed before the read
+	registers, err = c.ReadWriteRegisters(4, 3, 5, []uint16{0xAAAA, 0xBBBB})
+	if err != nil || len(registers) != 3 || registers[0] != 0x0017 || registers[1] != 0xAAAA || registers[2] != 0xBBBB {
+		t.Errorf("ReadWriteRegisters = %#04x, %v", registers, err)
+	}
+}
+
+func TestServerExceptions(t *testing.T) {
+	_, c := loopbackClient(t, NewMemory(10, 10, 10, 10))
+
+	tests := []struct {
+		name   string
+		call   func() error
+		fncode FnCode
+		excode ExCode
+	}{
+		{"ReadInputRegisters", func() error { _, err := c.ReadInputRegisters(9, 2); return err }, RDIR, IllegalDataAddress},
+		{"ReadCoils", func() error { _, err := c.ReadCoils(10, 1); return err }, RDCO, IllegalDataAddress},
+		{"WriteRegister", func() error { return c.WriteRegister(10, 1) }, WRSR, IllegalDataAddress},
+		{"WriteCoils", func() error { return c.WriteCoils(8, []bool{true, true, true}) }, WRMC, IllegalDataAddress},
+		{"MaskWriteRegister", func() error { return c.MaskWriteRegister(10, 0, 0) }, MWRR, IllegalDataAddress},
+		{"ReadFIFOQueue", func() error { _, err := c.ReadFIFOQueue(0); return err }, FIFO, IllegalFunction},
+		{"ReportServerId", func() error {
+			_, err := c.execute(context.Background(), &Request{FnCode: RSID})
+			return err
+		}, RSID, IllegalFunction},
+	}
+	for _, test := range tests {
+		err := test.call()
+		var exception *ExceptionError
+		if !errors.As(err, &exception) || exception.FnCode != test.fncode || !errors.Is(err, test.excode) {
+			t.Errorf("%s = %v; want %v exception to %v", test.name, err, test.excode, test.fncode)
+		}
+	}
+	// The connection remains usable after exceptions
+	if _, err := c.ReadHoldingRegisters(0, 10); err != nil {
+		t.Errorf("ReadHoldingRegisters after exceptions: %s", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	server, c := loopbackClient(t, NewMemory(1, 1, 1, 1))
+	if _, err := c.ReadCoils(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Open connections are closed with the server
+	if _, err := c.ReadCoils(0, 1); err == nil {
+		t.Error("ReadCoils on a closed server")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Serve(listener); err == nil {
+		t.Error("Serve on a closed server")
+	}
+}
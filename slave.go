@@ -0,0 +1,123 @@
+package modbusd
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Slave id used by a master to broadcast a request to all slaves, which do not reply
+	Broadcast byte = 0
+	// Highest slave id that can be addressed on a serial line
+	MaxSlaveId byte = 247
+)
+
+// Slave serves a DataModel as an RTU slave on a serial line
+type Slave struct {
+	SlaveId byte
+	Model   DataModel
+	Serial  *Serial
+
+	protocol *RTU
+}
+
+// NewSlave creates an instance of the Slave class answering to the slave id
+func NewSlave(serial *Serial, slaveid byte, model DataModel) (*Slave, error) {
+	if serial == nil {
+		return nil, fmt.Errorf("Illegal serial transport")
+	}
+	if model == nil {
+		return nil, fmt.Errorf("Illegal data model")
+	}
+	if slaveid == Broadcast || slaveid > MaxSlaveId {
+		return nil, fmt.Errorf("Illegal slave id: %v", slaveid)
+	}
+	protocol, err := NewRTU(slaveid)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create protocol: %s", err)
+	}
+	return &Slave{
+		SlaveId:  slaveid,
+		Model:    model,
+		Serial:   serial,
+		protocol: protocol,
+	}, nil
+}
+
+// Serve answers requests on the serial line until done is closed
+func (s *Slave) Serve(done chan bool) error {
+	if err := s.Serial.Connect(); err != nil {
+		return err
+	}
+	s.Serial.Lock()
+	s.Serial.Flush()
+	s.Serial.Unlock()
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		length, err := s.protocol.AssembleRequest(s.Serial, done)
+		if err != nil {
+			if isTimeout(err) {
+				// The bus is idle
+				continue
+			}
+			// Resynchronise on the next frame after a framing error
+			s.skip()
+			continue
+		}
+		if length == 0 {
+			continue
+		}
+		s.Serial.Lock()
+		frame := append([]byte{}, s.Serial.Buffer()[:length]...)
+		s.Serial.Flush()
+		s.Serial.Unlock()
+		if err = s.serve(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// serve answers a single request frame, silently ignoring frames in error or for other slaves
+func (s *Slave) serve(frame []byte) error {
+	request, err := s.protocol.DecodeRequest(frame)
+	if err != nil {
+		s.skip()
+		return nil
+	}
+	if request.SlaveId != s.SlaveId && request.SlaveId != Broadcast {
+		/*
+		 * Requests for other slaves are skipped, as are their replies
+		 * which carry the slave id of the addressed slave.
+		 */
+		s.skip()
+		return nil
+	}
+	pdu := respond(s.Model, request)
+	if request.SlaveId == Broadcast {
+		return nil
+	}
+	response, err := s.protocol.Reply(request, pdu)
+	if err != nil {
+		return err
+	}
+	// The reply may only start after the silence that ends the request
+	time.Sleep(s.protocol.silence(s.Serial))
+	return s.Serial.Send(response)
+}
+
+// skip discards characters until the bus has been silent for the inter-frame period
+func (s *Slave) skip() {
+	silence := s.protocol.silence(s.Serial)
+	for deadline := time.Now().Add(s.Serial.Timeout); time.Now().Before(deadline); {
+		if err := s.Serial.Receive(silence); err != nil {
+			break
+		}
+	}
+	s.Serial.Lock()
+	s.Serial.Flush()
+	s.Serial.Unlock()
+}
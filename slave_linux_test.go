@@ -0,0 +1,58 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 loong64 riscv64 s390x
+
+package modbusd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSerialSlave(t *testing.T) {
+	master, name := openPty(t)
+	// The silence ending a frame at a low baud rate allows for delays in writing the fragments
+	serial, err := NewSerial(name, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial.Timeout = 100 * time.Millisecond
+	memory := NewMemory(10, 10, 10, 10)
+	memory.HoldingRegisters[1] = 0xBEEF
+	slave, err := NewSlave(serial, 5, memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	errc := make(chan error, 1)
+	go func() { errc <- slave.Serve(done) }()
+	defer func() {
+		close(done)
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Requests to other slaves are not answered
+	master.Write(rtuFrame(t, 6, RDHR, []byte{0, 1, 0, 1}))
+	if response := readPty(master, 100*time.Millisecond); len(response) != 0 {
+		t.Fatalf("Unexpected response to another slave: %x", response)
+	}
+	// A fragmented request is assembled and answered
+	request := rtuFrame(t, 5, RDHR, []byte{0, 1, 0, 1})
+	master.Write(request[:3])
+	time.Sleep(time.Millisecond)
+	master.Write(request[3:])
+	expected := rtuFrame(t, 5, RDHR, []byte{2, 0xBE, 0xEF})
+	if response := readPty(master, time.Second); !bytes.Equal(response, expected) {
+		t.Fatalf("Response %x!=%x", response, expected)
+	}
+	// Exceptions are returned for illegal addresses
+	master.Write(rtuFrame(t, 5, RDHR, []byte{0, 20, 0, 1}))
+	expected = rtuFrame(t, 5, RDHR+FERR, []byte{byte(IllegalDataAddress)})
+	if response := readPty(master, time.Second); !bytes.Equal(response, expected) {
+		t.Fatalf("Exception %x!=%x", response, expected)
+	}
+}
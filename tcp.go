@@ -1,6 +1,7 @@
 package modbusd
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -9,12 +10,22 @@ import (
 
 const timeout time.Duration = 10 * time.Second
 
+// Framing determines how the TCP transport delimits the frames it receives
+type Framing int
+
+const (
+	FramingMBAP   Framing = 0 // Frames are read exactly as given by the MBAP header
+	FramingStream Framing = 1 // Data is buffered as it arrives and delimited by the protocol, as for RTU and ASCII over TCP
+)
+
 type TCP struct {
 	TransportBase
 	URL     string
 	ip      string
 	port    uint16
 	Timeout time.Duration
+	Retries int // Dial attempts, RETRIES if zero
+	Framing Framing
 
 	Conn net.Conn
 }
@@ -22,7 +33,7 @@ type TCP struct {
 // NewTCP creates an instance of the TCP transport class
 func NewTCP(ip string, port uint16, to time.Duration) (*TCP, error) {
 	return &TCP{
-		URL:     fmt.Sprintf("%s:%s", ip, strconv.FormatUint(uint64(port), 10)),
+		URL:     net.JoinHostPort(ip, strconv.FormatUint(uint64(port), 10)),
 		ip:      ip,
 		port:    port,
 		Timeout: to,
@@ -33,13 +44,25 @@ const RETRIES int = 5
 
 // Connect opens a TCP transport connection
 func (t *TCP) Connect() error {
+	return t.ConnectContext(context.Background())
+}
+
+// ConnectContext opens a TCP transport connection, giving up on dialing once the context is done
+func (t *TCP) ConnectContext(ctx context.Context) error {
 	if t.Timeout <= 0 {
 		t.Timeout = timeout
 	}
-	for retry := RETRIES; retry > 0; retry-- {
+	retries := t.Retries
+	if retries <= 0 {
+		retries = RETRIES
+	}
+	for retry := retries; retry > 0; retry-- {
 		dialer := net.Dialer{Timeout: t.Timeout}
 		var err error
-		if t.Conn, err = dialer.Dial("tcp", t.URL); err != nil {
+		if t.Conn, err = dialer.DialContext(ctx, "tcp", t.URL); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if retry == 1 {
 				return fmt.Errorf("Could not dial URL %s: %s", t.URL, err)
 			}
@@ -53,13 +76,17 @@ func (t *TCP) Connect() error {
 
 // Send implements the transmission of transport
 func (t *TCP) Send(adu *ADU) error {
+	return t.SendContext(context.Background(), adu)
+}
+
+// SendContext transmits on the transport, aborting the write once the context is done
+func (t *TCP) SendContext(ctx context.Context, adu *ADU) error {
 	var err error
 	if t.Conn == nil {
-		// Establish a new connection and close it when complete
-		if err = t.Connect(); err != nil {
+		// Establish a new connection, kept open for the response until closed
+		if err = t.ConnectContext(ctx); err != nil {
 			return err
 		}
-		defer t.Close()
 	}
 
 	if err = t.Conn.SetDeadline(time.Now().Add(t.Timeout)); err != nil {
@@ -70,33 +97,109 @@ func (t *TCP) Send(adu *ADU) error {
 	if aduBytes, err = adu.Bytes(); err != nil {
 		return err
 	}
-	if _, err = t.Conn.Write(aduBytes); err != nil {
+	stop := interrupt(ctx, t.Conn.SetDeadline)
+	_, err = t.Conn.Write(aduBytes)
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 	return nil
 }
 
-// Listen receives data on the transport
+/*
+ * Listen receives data on the transport within the deadline set when the
+ * request was sent, or until done is signalled. With MBAP framing exactly
+ * one frame is read, the 7 byte header followed by the length it gives, so
+ * that neither partial nor coalesced frames are buffered. With stream
+ * framing whatever arrives in a single read is buffered for the protocol to
+ * delimit.
+ */
 func (t *TCP) Listen(done chan bool) error {
+	ctx, cancel := doneContext(done)
+	defer cancel()
+	if err := t.ListenContext(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	// Being aborted by done is not an error
+	return nil
+}
+
+// ListenContext receives data on the transport within the deadline set when the request was sent, or until the context is done
+func (t *TCP) ListenContext(ctx context.Context) error {
+	if t.Conn == nil {
+		return fmt.Errorf("Transport not connected: %s", t.URL)
+	}
+	return t.read(ctx)
+}
+
+// Receive waits up to the given period for data on the transport
+func (t *TCP) Receive(wait time.Duration) error {
+	return t.ReceiveContext(context.Background(), wait)
+}
+
+// ReceiveContext waits up to the given period for data on the transport, or until the context is done
+func (t *TCP) ReceiveContext(ctx context.Context, wait time.Duration) error {
+	if t.Conn == nil {
+		return fmt.Errorf("Transport not connected: %s", t.URL)
+	}
+	if err := t.Conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return err
+	}
+	return t.read(ctx)
+}
+
+func (t *TCP) read(ctx context.Context) error {
 	var err error
-	var cnt int
-	// Create response to accomodate maximum length response in a single read
-	response := make([]byte, 256)
-	cnt, err = t.Conn.Read(response)
+	var response []byte
+	stop := interrupt(ctx, t.Conn.SetReadDeadline)
+	if t.Framing == FramingStream {
+		// Whatever arrives is appended to the response buffer, frames are delimited by the protocol
+		response = make([]byte, 260)
+		var cnt int
+		cnt, err = t.Conn.Read(response)
+		response = response[:cnt]
+	} else {
+		response, err = readMBAP(t.Conn)
+	}
+	stop()
 	if err != nil {
+		if t.Framing != FramingStream && len(response) > 0 {
+			/*
+			 * The remainder of a partially read frame would be taken for
+			 * the next header, so the connection is closed and opened
+			 * again by the next request.
+			 */
+			t.Close()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
-	if cnt > 0 {
+	if len(response) > 0 {
 		// Ensure exclusive access to the resource
 		t.M.Lock()
-		t.Response.Write(response[0:cnt])
+		t.Response.Write(response)
 		t.M.Unlock()
 	}
 	return nil
 }
 
+// streamed reports whether the transport buffers data as it arrives rather than as MBAP frames
+func streamed(t Transport) bool {
+	tcp, ok := t.(*TCP)
+	return !ok || tcp.Framing == FramingStream
+}
+
 // Close closes and cleans up after a connection
 func (t *TCP) Close() error {
-	t.Conn.Close()
-	return nil
+	if t.Conn == nil {
+		return nil
+	}
+	err := t.Conn.Close()
+	t.Conn = nil
+	return err
 }
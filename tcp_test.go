@@ -0,0 +1,120 @@
+package modbusd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawDevice accepts a single connection and writes the chunks to it with a pause in between
+func rawDevice(t *testing.T, chunks ...[]byte) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Loopback unavailable: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, chunk := range chunks {
+			conn.Write(chunk)
+			time.Sleep(5 * time.Millisecond)
+		}
+		// Hold the connection open until the client is done
+		conn.Read(make([]byte, 1))
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+// mbapFrame builds a ModbusTCP frame of the transaction with the PDU following the unit id
+func mbapFrame(tid uint16, pdu []byte) []byte {
+	length := len(pdu) + 1
+	return append([]byte{byte(tid >> 8), byte(tid), 0, 0, byte(length >> 8), byte(length), 1}, pdu...)
+}
+
+func TestTCPFraming(t *testing.T) {
+	first := mbapFrame(1, []byte{byte(RDHR), 2, 0x12, 0x34})
+	second := mbapFrame(2, []byte{byte(WRSR), 0, 1, 0, 2})
+	// The largest frame of 260 bytes
+	largest := mbapFrame(3, append([]byte{byte(RDFR), 251}, make([]byte, 251)...))
+	address := rawDevice(t,
+		append(append([]byte{}, first...), second...),
+		largest[:3], largest[3:100], largest[100:])
+	transport, _ := NewTCP(address.IP.String(), uint16(address.Port), time.Second)
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	transport.Conn.SetDeadline(time.Now().Add(time.Second))
+
+	// Coalesced frames are read one at a time and fragmented frames whole
+	for _, frame := range [][]byte{first, second, largest} {
+		if err := transport.Listen(nil); err != nil {
+			t.Fatalf("Listen: %s", err)
+		}
+		if !bytes.Equal(transport.Buffer(), frame) {
+			t.Fatalf("Buffer %x!=%x", transport.Buffer(), frame)
+		}
+		transport.Discard(len(frame))
+	}
+}
+
+func TestTCPFramingErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk []byte
+	}{
+		{"truncated", mbapFrame(1, []byte{byte(RDHR), 2, 0x12, 0x34})[:9]},
+		{"short length", []byte{0, 1, 0, 0, 0, 1, 1, 3}},
+		{"long length", []byte{0, 1, 0, 0, 0, 255, 1, 3}},
+	}
+	for _, test := range tests {
+		address := rawDevice(t, test.chunk)
+		transport, _ := NewTCP(address.IP.String(), uint16(address.Port), 100*time.Millisecond)
+		if err := transport.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		transport.Conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		if err := transport.Listen(nil); err == nil {
+			t.Errorf("Listen to a %s frame", test.name)
+		}
+		// The stream is out of step after a partial frame, so the connection is closed
+		if transport.Conn != nil || len(transport.Buffer()) != 0 {
+			t.Errorf("Connection kept after a %s frame: %x", test.name, transport.Buffer())
+		}
+		transport.Close()
+	}
+}
+
+func TestTCPStreamFraming(t *testing.T) {
+	rtu, _ := NewRTU(1)
+	pdu, _ := NewPDU(RDHR)
+	pdu.Data = []byte{4, 0x12, 0x34, 0xAB, 0xCD}
+	adu, _ := rtu.Encode(pdu)
+	response, _ := adu.Bytes()
+	// The RTU response arrives in fragments without an MBAP header
+	address := rawDevice(t, response[:1], response[1:5], response[5:])
+	u, err := NewURL(fmt.Sprintf("rotp://%s:%d/1-1/400001-2", address.IP, address.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if values, err := c.ReadHoldingRegisters(0, 2); err != nil || len(values) != 2 || values[0] != 0x1234 || values[1] != 0xABCD {
+		t.Errorf("ReadHoldingRegisters over RTU = %#04x, %v", values, err)
+	}
+
+	// RTU frames can not be assembled from MBAP framing
+	c.Transport.(*TCP).Framing = FramingMBAP
+	if _, err = c.ReadHoldingRegisters(0, 2); err == nil {
+		t.Error("ReadHoldingRegisters over RTU with MBAP framing")
+	}
+}
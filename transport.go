@@ -2,21 +2,43 @@ package modbusd
 
 import (
 	"bytes"
+	"context"
 	"sync"
+	"time"
 )
 
+/*
+ * Transport carries ADUs to and from a device. The context variants abort
+ * dialing, writing and reading as soon as the context is done, returning
+ * the error of the context.
+ */
 type Transport interface {
 	Connect() error
 	Send(*ADU) error
 	Listen(chan bool) error
 	Close() (err error)
 
+	ConnectContext(ctx context.Context) error
+	SendContext(ctx context.Context, adu *ADU) error
+	ListenContext(ctx context.Context) error
+
 	Lock()
 	Unlock()
 	Buffer() []byte
+	Discard(n int)
 	Flush()
 }
 
+/*
+ * Receiver is implemented by transports that can wait a bounded period for
+ * further data, which protocols without a length field rely on to detect the
+ * end of a frame.
+ */
+type Receiver interface {
+	Receive(wait time.Duration) error
+	ReceiveContext(ctx context.Context, wait time.Duration) error
+}
+
 // Creates an instance of the TransportBase class
 type TransportBase struct {
 	Id       uint
@@ -39,7 +61,62 @@ func (t *TransportBase) Buffer() []byte {
 	return t.Response.Bytes()
 }
 
+// Discard removes the first n bytes from the response buffer
+func (t *TransportBase) Discard(n int) {
+	t.Response.Next(n)
+}
+
 // Flush clears the content of the response buffer
 func (t *TransportBase) Flush() {
 	t.Response.Reset()
 }
+
+// isTimeout reports whether a transport error was caused by an expired deadline
+func isTimeout(err error) bool {
+	if e, ok := err.(interface{ Timeout() bool }); ok {
+		return e.Timeout()
+	}
+	return false
+}
+
+/*
+ * interrupt aborts the pending operations of a connection as soon as the
+ * context is done, by moving its deadline to the present. The returned stop
+ * function ends the watch and must be called once the operation completes,
+ * after which the connection deadline is no longer touched.
+ */
+func interrupt(ctx context.Context, deadline func(t time.Time) error) (stop func()) {
+	if ctx.Done() == nil {
+		// The context can never be cancelled
+		return func() {}
+	}
+	stopped := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			deadline(time.Now())
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+		<-finished
+	}
+}
+
+// doneContext derives a context that is cancelled once done is signalled
+func doneContext(done chan bool) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
@@ -2,57 +2,378 @@ package modbusd
 
 import (
 	"fmt"
+	"net"
+	neturl "net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Port on which Modbus TCP devices listen unless the URL names another
+const ModbusTCPPort uint16 = 502
+
 type URL struct {
 	SURL     string
-	IP       string
+	IP       string // Host name or IP address
 	PortNo   uint16
+	Device   string // Serial device path
+	Baud     uint32
+	DataBits byte
+	Parity   Parity
+	StopBits byte
 	SlaveId  byte
 	Timeout  uint
 	Protocol string
-	Address  uint64 // Absolute address
+	Address  uint64 // Absolute address, 6 digits from 0 whatever the addressing of the URL
 	Quantity uint16
+	Retries  int           // Connection attempts, the transport default if zero
+	Order    Order         // Byte and word order of values spread across registers
+	Delay    time.Duration // RTU inter-frame delay, 3.5 characters if zero
+	Values   []uint16      // Values written from the address, a read if empty
+
+	Addressing Addressing // Convention of the address in the URL
 }
 
+/*
+ * NewURL parses a modbus URL of the form
+ *   tcpp://127.0.0.1:502/slave-timeout/address-quantity?option=value
+ * where the host may also be a DNS name or a bracketed IPv6 address and
+ * the port defaults to 502. Client types on a serial line take the device
+ * path instead of the host, with the line settings either following it
+ *   rtup:///dev/ttyUSB0:9600-8E1/slave-timeout/address-quantity
+ * or given as options, which may also precede the slave and address
+ *   rtup:///dev/ttyUSB0?baud=9600&parity=E/slave-timeout/address-quantity
+ * where the data bits, parity and stop bits default to 8N1 (7E1 for ASCII)
+ * if omitted. The options are
+ *   retries                            connection attempts
+ *   order                              byte and word order, e.g. CDAB
+ *   baud, databits, parity, stopbits   serial line settings
+ *   delay                              RTU inter-frame delay, e.g. 5ms
+ *   values                             values written from the address, e.g. 1,0,1
+ *   addressing                         convention of the address: 6zero (the
+ *                                      default), 6one, 5one or prefix, e.g. hr:0
+ */
 func NewURL(surl string) (*URL, error) {
-	url := &URL{}
-	url.SURL = surl
-	components := strings.Split(surl, "//")
-	if len(components) != 2 {
+	u, err := neturl.Parse(surl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL: %s", err)
+	}
+	if u.Scheme == "" || u.Opaque != "" {
 		return nil, fmt.Errorf("Invalid URL: %s", surl)
 	}
-	url.Protocol = strings.Split(components[0], ":")[0]
-	components = strings.Split(components[1], "/")
-	if len(components) != 3 {
+	url := &URL{
+		SURL:     surl,
+		Protocol: u.Scheme,
+	}
+	if idx := strings.Index(u.RawQuery, "/"); idx >= 0 {
+		// The slave and address follow the options
+		path, err := neturl.PathUnescape(u.RawQuery[idx:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid URL: %s", err)
+		}
+		u.Path += path
+		u.RawQuery = u.RawQuery[:idx]
+	}
+
+	// The slave and address are the last two components of the path
+	components := strings.Split(u.Path, "/")
+	if len(components) < 3 {
 		return nil, fmt.Errorf("Invalid URL: %s", surl)
 	}
-	url.IP = strings.Split(components[0], ":")[0]
+	prefix := strings.Join(components[:len(components)-2], "/")
+	if err = url.parseSlave(components[len(components)-2]); err != nil {
+		return nil, err
+	}
+
+	serial := url.serial()
+	switch ClientType(strings.ToUpper(url.Protocol)) {
+	case REMOTEUNIT:
+		err = url.parseSerial(u.Host, prefix, "8N1")
+	case TEXT:
+		// The ASCII transmission mode defaults to 7 data bits and even parity
+		err = url.parseSerial(u.Host, prefix, "7E1")
+	default:
+		err = url.parseHost(u, prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := neturl.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL options: %s", err)
+	}
+	for key, values := range query {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("Repeated URL option: %s", key)
+		}
+		if err = url.parseOption(key, values[0], serial); err != nil {
+			return nil, err
+		}
+	}
+	// The address is parsed once its addressing is known
+	if err = url.parseAddress(components[len(components)-1]); err != nil {
+		return nil, err
+	}
+	if serial && url.Baud == 0 {
+		return nil, fmt.Errorf("Missing Baud rate in URL: %s", surl)
+	}
+	if len(url.Values) > 0 && len(url.Values) != int(url.Quantity) {
+		return nil, fmt.Errorf("Quantity does not match the number of values: %v!=%v", url.Quantity, len(url.Values))
+	}
+	return url, nil
+}
+
+// serial reports whether the client type communicates on a serial line
+func (url *URL) serial() bool {
+	switch ClientType(strings.ToUpper(url.Protocol)) {
+	case REMOTEUNIT, TEXT:
+		return true
+	default:
+		return false
+	}
+}
 
-	var err error
-	var u64 uint64
-	if u64, err = strconv.ParseUint(strings.Split(components[0], ":")[1], 10, 16); err != nil {
-		return nil, fmt.Errorf("Unable to parse Port number: %s", err)
+// parseSlave extracts the slave id and timeout, e.g. 1-5
+func (url *URL) parseSlave(component string) error {
+	fields := strings.Split(component, "-")
+	if len(fields) != 2 {
+		return fmt.Errorf("Invalid slave id and timeout: %s", component)
 	}
-	url.PortNo = uint16(u64)
-	if u64, err = strconv.ParseUint(strings.Split(components[1], "-")[0], 10, 8); err != nil {
-		return nil, fmt.Errorf("Unable to parse Slave Id: %s", err)
+	u64, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("Unable to parse Slave Id: %s", err)
 	}
 	url.SlaveId = byte(u64)
-	if u64, err = strconv.ParseUint(strings.Split(components[1], "-")[1], 10, 32); err != nil {
-		return nil, fmt.Errorf("Unable to parse Timeout: %s", err)
+	if u64, err = strconv.ParseUint(fields[1], 10, 32); err != nil {
+		return fmt.Errorf("Unable to parse Timeout: %s", err)
 	}
 	url.Timeout = uint(u64)
-	if u64, err = strconv.ParseUint(strings.Split(components[2], "-")[0], 10, 64); err != nil {
-		return nil, fmt.Errorf("Unable to parse Address: %s", err)
+	return nil
+}
+
+// parseAddress extracts the absolute address in the addressing of the URL and the quantity, e.g. 400001-10
+func (url *URL) parseAddress(component string) error {
+	fields := strings.Split(component, "-")
+	if len(fields) != 2 {
+		return fmt.Errorf("Invalid address and quantity: %s", component)
+	}
+	u64, err := url.Addressing.canonical(fields[0])
+	if err != nil {
+		return fmt.Errorf("Unable to parse Address: %s", err)
 	}
 	url.Address = u64
-	if u64, err = strconv.ParseUint(strings.Split(components[2], "-")[1], 10, 16); err != nil {
-		return nil, fmt.Errorf("Unable to parse Quantity: %s", err)
+	if u64, err = strconv.ParseUint(fields[1], 10, 16); err != nil {
+		return fmt.Errorf("Unable to parse Quantity: %s", err)
 	}
 	url.Quantity = uint16(u64)
+	return nil
+}
 
-	return url, nil
+// parseHost extracts the host name or address and the port of a client type on a network
+func (url *URL) parseHost(u *neturl.URL, prefix string) error {
+	if prefix != "" {
+		return fmt.Errorf("Invalid URL path: %s", u.Path)
+	}
+	if url.IP = u.Hostname(); url.IP == "" {
+		return fmt.Errorf("Missing host in URL: %s", url.SURL)
+	}
+	if strings.Contains(url.IP, ":") && !strings.HasPrefix(u.Host, "[") {
+		// IPv6 addresses are bracketed to separate them from the port
+		return fmt.Errorf("Invalid host in URL: %s", u.Host)
+	}
+	url.PortNo = ModbusTCPPort
+	if port := u.Port(); port != "" {
+		u64, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("Unable to parse Port number: %s", err)
+		}
+		url.PortNo = uint16(u64)
+	}
+	return nil
+}
+
+/*
+ * parseSerial extracts the device and its line settings, e.g. /dev/ttyUSB0:9600-8E1,
+ * from the path. A device without a directory, such as COM1, is named in place of
+ * the host and takes its line settings from the options.
+ */
+func (url *URL) parseSerial(host string, prefix string, framing string) error {
+	device := prefix
+	if host != "" {
+		if prefix != "" {
+			return fmt.Errorf("Invalid serial device: %s%s", host, prefix)
+		}
+		device = host
+	}
+	if device == "" {
+		return fmt.Errorf("Missing serial device in URL: %s", url.SURL)
+	}
+	settings := ""
+	if idx := strings.LastIndex(device, ":"); idx > 0 {
+		device, settings = device[:idx], device[idx+1:]
+	}
+	url.Device = device
+	if settings == "" {
+		return url.parseFraming(framing)
+	}
+
+	fields := strings.Split(settings, "-")
+	if len(fields) > 2 {
+		return fmt.Errorf("Invalid serial line settings: %s", settings)
+	}
+	if err := url.parseOption("baud", fields[0], true); err != nil {
+		return err
+	}
+	if len(fields) == 2 {
+		framing = fields[1]
+	}
+	return url.parseFraming(framing)
+}
+
+// parseFraming extracts the data bits, parity and stop bits, e.g. 8E1
+func (url *URL) parseFraming(framing string) error {
+	if len(framing) != 3 {
+		return fmt.Errorf("Invalid serial framing: %s", framing)
+	}
+	for idx, key := range []string{"databits", "parity", "stopbits"} {
+		if err := url.parseOption(key, framing[idx:idx+1], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseOption sets the URL member named by an option
+func (url *URL) parseOption(key string, value string, serial bool) error {
+	switch key {
+	case "baud", "databits", "parity", "stopbits":
+		if !serial {
+			return fmt.Errorf("URL option requires a serial line: %s", key)
+		}
+	case "delay":
+		if ClientType(strings.ToUpper(url.Protocol)) != REMOTEUNIT {
+			return fmt.Errorf("URL option requires RTU on a serial line: %s", key)
+		}
+	}
+	switch key {
+	case "retries":
+		u64, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return fmt.Errorf("Unable to parse Retries: %s", err)
+		}
+		url.Retries = int(u64)
+	case "order":
+		order, err := ParseOrder(value)
+		if err != nil {
+			return err
+		}
+		url.Order = order
+	case "baud":
+		u64, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || u64 == 0 {
+			return fmt.Errorf("Unable to parse Baud rate: %s", value)
+		}
+		url.Baud = uint32(u64)
+	case "databits":
+		u64, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || u64 < 5 || u64 > 8 {
+			return fmt.Errorf("Unable to parse Data bits: %s", value)
+		}
+		url.DataBits = byte(u64)
+	case "parity":
+		parity := Parity(0)
+		if len(value) == 1 {
+			parity = Parity(strings.ToUpper(value)[0])
+		}
+		switch parity {
+		case NoParity, EvenParity, OddParity:
+			url.Parity = parity
+		default:
+			return fmt.Errorf("Unable to parse Parity: %s", value)
+		}
+	case "stopbits":
+		u64, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || u64 < 1 || u64 > 2 {
+			return fmt.Errorf("Unable to parse Stop bits: %s", value)
+		}
+		url.StopBits = byte(u64)
+	case "addressing":
+		addressing, err := ParseAddressing(value)
+		if err != nil {
+			return err
+		}
+		url.Addressing = addressing
+	case "values":
+		fields := strings.Split(value, ",")
+		url.Values = make([]uint16, len(fields))
+		for idx, field := range fields {
+			u64, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return fmt.Errorf("Unable to parse Values: %s", err)
+			}
+			url.Values[idx] = uint16(u64)
+		}
+	case "delay":
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("Unable to parse Delay: %s", value)
+		}
+		url.Delay = delay
+	default:
+		return fmt.Errorf("Unknown URL option: %s", key)
+	}
+	return nil
+}
+
+/*
+ * String formats the URL in the form parsed by NewURL, with the serial line
+ * settings and any options that differ from their defaults in the query.
+ */
+func (url *URL) String() string {
+	query := make(neturl.Values)
+	if url.Retries > 0 {
+		query.Set("retries", strconv.Itoa(url.Retries))
+	}
+	if url.Order != ABCD {
+		query.Set("order", url.Order.String())
+	}
+	if len(url.Values) > 0 {
+		values := make([]string, len(url.Values))
+		for idx, value := range url.Values {
+			values[idx] = strconv.FormatUint(uint64(value), 10)
+		}
+		query.Set("values", strings.Join(values, ","))
+	}
+	if url.Addressing != ZeroBased6 {
+		query.Set("addressing", url.Addressing.String())
+	}
+	address, err := url.Addressing.format(url.Address)
+	if err != nil {
+		// An address the addressing can not express is kept in 6 digits from 0
+		query.Del("addressing")
+		address = strconv.FormatUint(url.Address, 10)
+	}
+	path := fmt.Sprintf("/%d-%d/%s-%d", url.SlaveId, url.Timeout, address, url.Quantity)
+	u := neturl.URL{Scheme: url.Protocol}
+	if url.serial() {
+		query.Set("baud", strconv.FormatUint(uint64(url.Baud), 10))
+		query.Set("databits", strconv.Itoa(int(url.DataBits)))
+		query.Set("parity", string(rune(url.Parity)))
+		query.Set("stopbits", strconv.Itoa(int(url.StopBits)))
+		if url.Delay > 0 {
+			query.Set("delay", url.Delay.String())
+		}
+		if strings.HasPrefix(url.Device, "/") {
+			u.Path = url.Device + path
+		} else {
+			u.Host = url.Device
+			u.Path = path
+		}
+	} else {
+		u.Host = net.JoinHostPort(url.IP, strconv.FormatUint(uint64(url.PortNo), 10))
+		u.Path = path
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
 }
@@ -0,0 +1,119 @@
+package modbusd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		protocol string
+		ip       string
+		port     uint16
+		slave    byte
+		timeout  uint
+		address  uint64
+		quantity uint16
+	}{
+		// The legacy form of an IPv4 address and port
+		{"tcpp://10.0.0.7:502/1-5/400001-2", "tcpp", "10.0.0.7", 502, 1, 5, 400001, 2},
+		// The port defaults to 502
+		{"tcpp://10.0.0.7/3-1/300010-1", "tcpp", "10.0.0.7", 502, 3, 1, 300010, 1},
+		{"tcpp://[fe80::1]:1502/2-1/300001-4", "tcpp", "fe80::1", 1502, 2, 1, 300001, 4},
+		{"tcpp://[::1]/2-1/000005-8", "tcpp", "::1", 502, 2, 1, 5, 8},
+		{"TCPP://plc-7.site.local:5020/247-10/100000-16", "tcpp", "plc-7.site.local", 5020, 247, 10, 100000, 16},
+	}
+	for _, test := range tests {
+		u, err := NewURL(test.url)
+		if err != nil {
+			t.Errorf("NewURL(%q): %s", test.url, err)
+			continue
+		}
+		if u.Protocol != test.protocol || u.IP != test.ip || u.PortNo != test.port || u.SlaveId != test.slave ||
+			u.Timeout != test.timeout || u.Address != test.address || u.Quantity != test.quantity {
+			t.Errorf("NewURL(%q) = %+v", test.url, u)
+		}
+	}
+}
+
+func TestNewURLOptions(t *testing.T) {
+	u, err := NewURL("tcpp://plc.local/1-5/400001-2?order=cdab&retries=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Order != CDAB || u.Retries != 3 || u.PortNo != ModbusTCPPort {
+		t.Errorf("Options not applied: %+v", u)
+	}
+	u, err = NewURL("rtup://COM1/1-5/400001-1?baud=19200&parity=o&stopbits=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Device != "COM1" || u.Baud != 19200 || u.Parity != OddParity || u.StopBits != 2 || u.DataBits != 8 {
+		t.Errorf("Serial options not applied: %+v", u)
+	}
+}
+
+func TestNewURLMalformed(t *testing.T) {
+	for _, url := range []string{
+		"",
+		"tcpp",
+		"tcpp://",
+		"%zz",
+		"tcpp:opaque/1-5/1-1",
+		"tcpp://h:502/1-5",
+		"tcpp://h:502/1/400001-1",
+		"tcpp://h:502/1-5/400001",
+		"tcpp://h/-/-",
+		"tcpp://h/x/1-5/1-1",
+		"tcpp://h:99999/1-5/1-1",
+		"tcpp://:502/1-5/1-1",
+		"tcpp://[::1/1-1/1-1",
+		"tcpp://::1/1-1/1-1",
+		"tcpp://h/1-5/1-1?%zz",
+		"tcpp://h/1-5/1-1?bogus=1",
+		"tcpp://h/1-5/1-1?retries=1&retries=2",
+		"tcpp://h/1-5/1-1?order=XYZW",
+		"tcpp://h/1-5/1-1?baud=9600",
+		"rtup:///1-1/1-1",
+		"rtup:///dev/x/1-1/1-1",
+		"rtup:///dev/x:9600-8X1/1-1/1-1",
+		"rtup:///dev/x:9600-9N1/1-1/1-1",
+		"rtup:///dev/x/1-1/1-1?baud=0",
+	} {
+		if u, err := NewURL(url); err == nil {
+			t.Errorf("NewURL(%q) = %+v; want an error", url, u)
+		}
+	}
+}
+
+func TestURLString(t *testing.T) {
+	tests := map[string]string{
+		"tcpp://1.2.3.4:502/1-5/400001-1":                         "tcpp://1.2.3.4:502/1-5/400001-1",
+		"TCPP://plc.local/1-5/400001-1?order=cdab&retries=3":      "tcpp://plc.local:502/1-5/400001-1?order=CDAB&retries=3",
+		"tcpp://[fe80::1]:1502/2-1/300001-4":                      "tcpp://[fe80::1]:1502/2-1/300001-4",
+		"rtup:///dev/ttyUSB0:9600-8E1/1-5/400001-1":               "rtup:///dev/ttyUSB0/1-5/400001-1?baud=9600&databits=8&parity=E&stopbits=1",
+		"ascp:///dev/ttyS0:4800/1-5/400001-1":                     "ascp:///dev/ttyS0/1-5/400001-1?baud=4800&databits=7&parity=E&stopbits=1",
+		"rtup://COM1/1-5/400001-1?baud=19200&parity=o&stopbits=2": "rtup://COM1/1-5/400001-1?baud=19200&databits=8&parity=O&stopbits=2",
+	}
+	for url, canonical := range tests {
+		u, err := NewURL(url)
+		if err != nil {
+			t.Errorf("NewURL(%q): %s", url, err)
+			continue
+		}
+		if u.String() != canonical {
+			t.Errorf("NewURL(%q).String() = %q; want %q", url, u.String(), canonical)
+		}
+		// The canonical form parses to the same URL
+		v, err := NewURL(u.String())
+		if err != nil {
+			t.Errorf("NewURL(%q): %s", u.String(), err)
+			continue
+		}
+		u.SURL, v.SURL = "", ""
+		if !reflect.DeepEqual(u, v) {
+			t.Errorf("Round trip of %q: %+v != %+v", url, v, u)
+		}
+	}
+}